@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os/user"
 
+	"github.com/tempuslabs/s3s2/encrypt"
 	"github.com/tempuslabs/s3s2/options"
 	log "github.com/sirupsen/logrus"
 
@@ -40,6 +41,13 @@ var configCmd = &cobra.Command{
 		fmt.Println("Please specify a public key to use (file path or url). Leave blank if intending to use SSM.")
 		pubkey := prompt.Input("> ", completer)
 
+		// keys fetched from a url are only used when they match the pinned fingerprint
+		var fingerprint string
+		if encrypt.IsKeyURL(pubkey) {
+			fmt.Println("Please specify the fingerprint of the public key published at that url.")
+			fingerprint = prompt.Input("> ", completer)
+		}
+
 		fmt.Println("Please specify the ssm parameter name corresponding to the public key.")
         ssmpubkey := prompt.Input("> ", completer)
 
@@ -53,6 +61,7 @@ var configCmd = &cobra.Command{
 			Region:    region,
 			Prefix:    prefix,
 			PubKey:    pubkey,
+			ReceiverKeyFingerprint: fingerprint,
 			SSMPubKey: ssmpubkey,
 			SSMPrivKey: ssmprivkey,
 			AwsProfile: awsprofile,
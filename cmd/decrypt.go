@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -9,21 +11,21 @@ import (
 	"sync"
 	"time"
 
-	"golang.org/x/crypto/openpgp/packet"
+	"github.com/ProtonMail/go-crypto/openpgp"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
-	session "github.com/aws/aws-sdk-go/aws/session"
-
 	log "github.com/sirupsen/logrus"
 
 	// local
-	aws_helpers "github.com/tempuslabs/s3s2/aws_helpers"
+	compress "github.com/tempuslabs/s3s2/compress"
 	encrypt "github.com/tempuslabs/s3s2/encrypt"
 	file "github.com/tempuslabs/s3s2/file"
+	journal "github.com/tempuslabs/s3s2/journal"
 	manifest "github.com/tempuslabs/s3s2/manifest"
 	options "github.com/tempuslabs/s3s2/options"
+	storage "github.com/tempuslabs/s3s2/storage"
 	utils "github.com/tempuslabs/s3s2/utils"
 	wc_helpers "github.com/tempuslabs/s3s2/wc_helpers"
 	zip "github.com/tempuslabs/s3s2/zip"
@@ -46,6 +48,14 @@ var decryptCmd = &cobra.Command{
 		viper.BindPFlag("ssm-public-key", cmd.Flags().Lookup("ssm-public-key"))
 		viper.BindPFlag("is-gcs", cmd.Flags().Lookup("is-gcs"))
 		viper.BindPFlag("filter-files", cmd.Flags().Lookup("filter-files"))
+		viper.BindPFlag("streaming", cmd.Flags().Lookup("streaming"))
+		viper.BindPFlag("skip-existing", cmd.Flags().Lookup("skip-existing"))
+		viper.BindPFlag("passphrase-env", cmd.Flags().Lookup("passphrase-env"))
+		viper.BindPFlag("passphrase-fd", cmd.Flags().Lookup("passphrase-fd"))
+		viper.BindPFlag("ssm-passphrase", cmd.Flags().Lookup("ssm-passphrase"))
+		viper.BindPFlag("kms-endpoint-url", cmd.Flags().Lookup("kms-endpoint-url"))
+		viper.BindPFlag("vault-addr", cmd.Flags().Lookup("vault-addr"))
+		viper.BindPFlag("vault-transit-key", cmd.Flags().Lookup("vault-transit-key"))
 		cmd.MarkFlagRequired("directory")
 		cmd.MarkFlagRequired("region")
 	},
@@ -56,8 +66,10 @@ var decryptCmd = &cobra.Command{
 
 		// top level clients
 		sess := utils.GetAwsSession(opts)
-		_pubKey := encrypt.GetPubKey(sess, opts)
-		_privKey := encrypt.GetPrivKey(sess, opts)
+		_senders := encrypt.GetTrustedSenders(opts)
+
+		backend, err := storage.NewBackend(opts)
+		utils.PanicIfError("Unable to create storage backend - ", err)
 
 		os.MkdirAll(opts.Directory, os.ModePerm)
 
@@ -67,11 +79,25 @@ var decryptCmd = &cobra.Command{
 			log.Info("Detected manifest file...")
 
 			target_manifest_path := filepath.Join(opts.Directory, filepath.Base(opts.File))
-			fn, err := aws_helpers.DownloadFile(sess, opts.Bucket, opts.Org, opts.File, target_manifest_path, opts)
+			fn, err := storage.DownloadFile(backend, storage.ObjectKey(opts.Org, opts.File), target_manifest_path)
 			utils.PanicIfError("Unable to download file - ", err)
 
+			verifyManifestSignature(backend, _senders, fn, opts)
+
 			m := manifest.ReadManifest(fn)
 			batch_folder := m.Folder
+
+			// envelope batches carry the wrapped data key of every file, only GPG batches need the receiver's private key
+			var _keyring openpgp.EntityList
+			_dataKeys, err := encrypt.NewDataKeys(sess, m.Format, opts)
+			utils.PanicIfError("Unable to set up data keys - ", err)
+			if _dataKeys == nil {
+				_keyring = encrypt.GetPrivKey(sess, opts)
+			}
+			checkCrypto(backend, m, _keyring, opts)
+			if m.Sealed != nil {
+				m = openSealedManifest(backend, _keyring, _senders, _dataKeys, m, opts)
+			}
 			file_structs := m.Files
 
 			if len(opts.FilterFiles) >= 1 {
@@ -92,39 +118,48 @@ var decryptCmd = &cobra.Command{
 				file_structs = file_filtered
 			}
 
+			// every finished file is recorded so a rerun with --skip-existing can pick up where this one stopped
+			progress, err := journal.OpenProgress(filepath.Join(opts.Directory, fmt.Sprintf("s3s2_progress_%s.jsonl", batch_folder)))
+			utils.PanicIfError("Unable to open progress file - ", err)
+			defer progress.Close()
+
+			// every decrypted file is checked against the size and checksum share recorded
+			report := manifest.NewReport(opts.File, m)
+
 			var wg sync.WaitGroup
 			sem := make(chan int, opts.Parallelism)
 
 			for _, fs := range file_structs {
 				wg.Add(1)
-				go func(wg *sync.WaitGroup, sess *session.Session, _pubkey *packet.PublicKey, _privKey *packet.PrivateKey, folder string, fs file.File, opts options.Options) {
+				go func(wg *sync.WaitGroup, backend storage.Backend, _keyring openpgp.EntityList, _senders openpgp.EntityList, folder string, fs file.File, opts options.Options) {
 					sem <- 1
 					defer func() { <-sem }()
 					defer wg.Done()
-					// if block is for cases where AWS session expires, so we re-create session and attempt file again
-					// RefreshSession logic is not needed here since stale sessions are handled in the if block
-					err, skipped := decryptFile(sess, _pubKey, _privKey, m, fs, opts)
+
+					fn_output := filepath.Join(opts.Directory, fs.GetSourceName("decrypted"))
+					if opts.SkipExisting && alreadyDecrypted(progress, fs, fn_output) {
+						log.Debugf("Skipping file '%s' - already decrypted to '%s'", fs.Name, fn_output)
+						report.Record(fs, nil)
+						return
+					}
+
+					// if block is for cases where AWS session expires, backends fetch a fresh session per call so we attempt file again
+					err, skipped := decryptFile(backend, _keyring, _senders, _dataKeys, m, fs, opts)
 					if err != nil || skipped {
-						sess = utils.GetAwsSession(opts)
-						err, skipped := decryptFile(sess, _pubKey, _privKey, m, fs, opts)
+						err, skipped = decryptFile(backend, _keyring, _senders, _dataKeys, m, fs, opts)
 						if err != nil {
 							log.Warn("Error during decrypt-file session expiration if block!")
 							log.Errorf("Error: '%v'", err)
 							panic(err)
 						}
-						if skipped {
-							f, err := os.OpenFile("skipped.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-							if err != nil {
-								log.Errorf("Error opening skipped.txt: %v", err)
-							} else {
-								if _, err := f.WriteString(fs.Name + "\n"); err != nil {
-									log.Errorf("Error writing to skipped.txt: %v", err)
-								}
-								f.Close()
-							}
-						}
 					}
-					if skipped {
+					if !skipped {
+						// files that fail verification are left out of the progress file so a rerun tries them again
+						if report.Check(fs, fn_output) == nil {
+							err = progress.Record(fs.Name, fn_output)
+							utils.PanicIfError("Unable to record progress - ", err)
+						}
+					} else {
 						f, err := os.OpenFile("skipped.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 						if err != nil {
 							log.Errorf("Error opening skipped.txt: %v", err)
@@ -135,14 +170,108 @@ var decryptCmd = &cobra.Command{
 							f.Close()
 						}
 					}
-				}(&wg, sess, _pubKey, _privKey, batch_folder, fs, opts)
+				}(&wg, backend, _keyring, _senders, batch_folder, fs, opts)
 			}
 			wg.Wait()
+
+			report_path, err := report.Write(opts.Directory)
+			utils.PanicIfError("Unable to write integrity report - ", err)
+			if len(report.Failures) > 0 {
+				log.Panicf("%d files failed verification, see '%s'", len(report.Failures), report_path)
+			}
+			log.Infof("Verified %d files, integrity report written to '%s'", report.Verified, report_path)
 		}
 	},
 }
 
-func decryptFile(sess *session.Session, _pubkey *packet.PublicKey, _privkey *packet.PrivateKey, m manifest.Manifest, fs file.File, opts options.Options) (error, bool) {
+// Checks the detached signature share uploads next to the manifest, refusing the batch in strict mode
+func verifyManifestSignature(backend storage.Backend, _senders openpgp.EntityList, manifest_path string, opts options.Options) {
+	if len(_senders) == 0 {
+		return
+	}
+
+	err := func() error {
+		data, err := os.ReadFile(manifest_path)
+		if err != nil {
+			return err
+		}
+		body, err := backend.Get(storage.ObjectKey(opts.Org, opts.File+manifest.SignatureSuffix))
+		if err != nil {
+			return fmt.Errorf("manifest is not signed - %s", err)
+		}
+		defer body.Close()
+		signature, err := io.ReadAll(body)
+		if err != nil {
+			return err
+		}
+		return encrypt.VerifyDetached(_senders, data, signature)
+	}()
+
+	if err == nil {
+		log.Info("Verified manifest signature")
+	} else if opts.Strict {
+		log.Panicf("Refusing batch, unable to verify the manifest was signed by a trusted sender - %s", err)
+	} else {
+		log.Warnf("Unable to verify the manifest was signed by a trusted sender - %s", err)
+	}
+}
+
+// Fails before the batch is downloaded when the keys provided can't decrypt it
+func checkCrypto(backend storage.Backend, m manifest.Manifest, _keyring openpgp.EntityList, opts options.Options) {
+	if m.Crypto == nil {
+		return
+	}
+	log.Debugf("Batch encrypted with %s by s3s2 %s", m.Crypto.Cipher, m.Crypto.Version)
+	if m.Format == encrypt.FormatTransit && m.Crypto.KeyId != opts.VaultTransitKey {
+		log.Panicf("Refusing batch, it is sealed with transit key '%s' rather than '%s'.", m.Crypto.KeyId, opts.VaultTransitKey)
+	}
+	if _keyring == nil {
+		return
+	}
+	stale, err := m.CheckRecipients(backend, _keyring)
+	if err != nil {
+		log.Panicf("Refusing batch, %s.", err)
+	}
+	if stale {
+		log.Warn("The manifest's recipients are out of date, the batch was re-keyed after it was shared")
+	}
+}
+
+// Batches shared with opaque object names only list their files in the encrypted manifest the stub points to
+func openSealedManifest(backend storage.Backend, _keyring openpgp.EntityList, _senders openpgp.EntityList, _datakeys encrypt.DataKeys, m manifest.Manifest, opts options.Options) manifest.Manifest {
+	// without data keys the manifest would be read as a GPG message, rekey never has them
+	if m.Sealed.DataKey != "" && _datakeys == nil {
+		log.Panic("The manifest of this batch is sealed under a data key, which can only be opened with the KMS or transit key it was shared with.")
+	}
+	sealed_key := storage.ObjectKey(m.Organization, m.Folder, m.Sealed.Name)
+	body, err := backend.Get(sealed_key)
+	utils.PanicIfError("Unable to download encrypted manifest - ", err)
+	defer body.Close()
+
+	// the manifest is encrypted the way the objects are, only it isn't zipped
+	plain, err := newObjectReader(_keyring, _senders, _datakeys, m, file.File{Compression: compress.Gzip, DataKey: m.Sealed.DataKey}, body, opts)
+	utils.PanicIfError("Unable to decrypt manifest - ", err)
+	defer plain.Close()
+
+	m, err = m.Unseal(plain)
+	utils.PanicIfError("Error reading encrypted manifest - ", err)
+	log.Infof("Decrypted manifest '%s' listing %d files", sealed_key, len(m.Files))
+	return m
+}
+
+// Without a checksum in the manifest, only files this decrypt recorded finishing are trusted
+func alreadyDecrypted(progress *journal.Progress, fs file.File, fn_output string) bool {
+	if fs.Sha256 == "" && !progress.Done(fs.Name, fn_output) {
+		return false
+	}
+	return fs.Verify(fn_output) == nil
+}
+
+func decryptFile(backend storage.Backend, _keyring openpgp.EntityList, _senders openpgp.EntityList, _datakeys encrypt.DataKeys, m manifest.Manifest, fs file.File, opts options.Options) (error, bool) {
+	if opts.Streaming {
+		return decryptFileStreaming(backend, _keyring, _senders, _datakeys, m, fs, opts)
+	}
+
 	start := time.Now()
 	skipped := false
 	log.Debugf("Starting decryption on file '%s'", fs.Name)
@@ -155,10 +284,11 @@ func decryptFile(sess *session.Session, _pubkey *packet.PublicKey, _privkey *pac
 	fn_zip := fs.GetZipName(opts.Directory)
 	fn_decrypt := fs.GetSourceName("decrypted")
 
-	nested_dir := filepath.Dir(target_path)
+	// the zip is written next to where the file ends up, the object may be stored under an opaque name
+	nested_dir := filepath.Dir(fn_zip)
 	os.MkdirAll(nested_dir, os.ModePerm)
 
-	_, err := aws_helpers.DownloadFile(sess, opts.Bucket, m.Organization, aws_key, target_path, opts)
+	_, err := storage.DownloadFile(backend, storage.ObjectKey(m.Organization, aws_key), target_path)
 	utils.PanicIfError("Unable to download file - ", err)
 
 	// Check if downloaded file is empty
@@ -171,7 +301,17 @@ func decryptFile(sess *session.Session, _pubkey *packet.PublicKey, _privkey *pac
 		log.Warningf("Downloaded file '%s' is empty", target_path)
 		skipped = true
 	} else {
-		encrypt.DecryptFile(_pubkey, _privkey, target_path, fn_zip, opts)
+		if _datakeys != nil {
+			var data_key []byte
+			if data_key, err = _datakeys.Unwrap(fs.DataKey); err == nil {
+				err = encrypt.EnvelopeDecryptFile(data_key, target_path, fn_zip, fs.Compression)
+			}
+		} else {
+			err = encrypt.DecryptFile(_keyring, _senders, target_path, fn_zip, m.Format, fs.Compression, opts)
+		}
+		if err != nil {
+			return err, skipped
+		}
 		zip.UnZipFile(fn_zip, fn_decrypt, opts.Directory)
 
 		utils.Timing(start, fmt.Sprintf("\tProcessed file '%s' in ", fs.Name)+"%f seconds")
@@ -180,6 +320,65 @@ func decryptFile(sess *session.Session, _pubkey *packet.PublicKey, _privkey *pac
 	return err, skipped
 }
 
+// Object is decrypted and unzipped as it downloads - only the final file is written to disk
+func decryptFileStreaming(backend storage.Backend, _keyring openpgp.EntityList, _senders openpgp.EntityList, _datakeys encrypt.DataKeys, m manifest.Manifest, fs file.File, opts options.Options) (error, bool) {
+	start := time.Now()
+	log.Debugf("Starting streaming decryption on file '%s'", fs.Name)
+	// enforce posix path
+	fs.Name = utils.ToPosixPath(fs.Name)
+
+	aws_key := storage.ObjectKey(m.Organization, fs.GetEncryptedName(m.Folder))
+	fn_decrypt := fs.GetSourceName("decrypted")
+
+	body, err := backend.Get(aws_key)
+	if err != nil {
+		log.Errorf("Unable to download file '%s' - %v", aws_key, err)
+		return err, false
+	}
+	defer body.Close()
+
+	// Check if downloaded file is empty
+	buffered := bufio.NewReader(body)
+	if _, err := buffered.Peek(1); err == io.EOF {
+		log.Warningf("Downloaded file '%s' is empty", aws_key)
+		return nil, true
+	}
+
+	plain, err := newObjectReader(_keyring, _senders, _datakeys, m, fs, buffered, opts)
+	if err != nil {
+		log.Errorf("Unable to decrypt file '%s' - %v", aws_key, err)
+		return err, false
+	}
+	defer plain.Close()
+
+	err = zip.UnZipStream(plain, fn_decrypt, opts.Directory)
+	if err == nil {
+		// read to the end of the message so its integrity check is verified
+		_, err = io.Copy(io.Discard, plain)
+	}
+	if err != nil {
+		log.Errorf("Unable to extract file '%s' - %v", aws_key, err)
+		// the sender is only verified once the whole object is read, by which point the file is already extracted
+		os.Remove(filepath.Join(opts.Directory, fn_decrypt))
+		return err, false
+	}
+
+	utils.Timing(start, fmt.Sprintf("\tProcessed file '%s' in ", fs.Name)+"%f seconds")
+	return nil, false
+}
+
+// Objects of envelope batches are opened with their own data key, otherwise decrypted with the receiver's keyring
+func newObjectReader(_keyring openpgp.EntityList, _senders openpgp.EntityList, _datakeys encrypt.DataKeys, m manifest.Manifest, fs file.File, in io.Reader, opts options.Options) (io.ReadCloser, error) {
+	if _datakeys == nil {
+		return encrypt.NewDecryptReader(_keyring, _senders, in, m.Format, fs.Compression, opts)
+	}
+	data_key, err := _datakeys.Unwrap(fs.DataKey)
+	if err != nil {
+		return nil, err
+	}
+	return encrypt.NewEnvelopeReader(data_key, in, fs.Compression)
+}
+
 func buildDecryptOptions() options.Options {
 	bucket := viper.GetString("bucket")
 	file := viper.GetString("file")
@@ -199,6 +398,25 @@ func buildDecryptOptions() options.Options {
 	isGCS := viper.GetBool("is-gcs")
 	parallelism := viper.GetInt("parallelism")
 	filterFiles := viper.GetString("filter-files")
+	streaming := viper.GetBool("streaming")
+	skipExisting := viper.GetBool("skip-existing")
+	trustedSenders := viper.GetString("trusted-senders")
+	strict := viper.GetBool("strict")
+	passphraseEnv := viper.GetString("passphrase-env")
+	passphraseFd := viper.GetString("passphrase-fd")
+	ssmPassphrase := viper.GetString("ssm-passphrase")
+	endpointUrl := viper.GetString("endpoint-url")
+	kmsEndpointUrl := viper.GetString("kms-endpoint-url")
+	vaultAddr := viper.GetString("vault-addr")
+	vaultTransitKey := viper.GetString("vault-transit-key")
+	pathStyle := viper.GetBool("path-style")
+	caBundle := viper.GetString("ca-bundle")
+	azureAccount := viper.GetString("azure-account")
+	azureAccountKey := viper.GetString("azure-account-key")
+	azureSasToken := viper.GetString("azure-sas-token")
+	azureConnectionString := viper.GetString("azure-connection-string")
+	sftpKey := viper.GetString("sftp-key")
+	sftpKnownHosts := viper.GetString("sftp-known-hosts")
 
 	options := options.Options{
 		Bucket:      bucket,
@@ -214,6 +432,29 @@ func buildDecryptOptions() options.Options {
 		AwsProfile:  awsProfile,
 		Parallelism: parallelism,
 		FilterFiles: filterFiles,
+		Streaming:   streaming,
+
+		SkipExisting:   skipExisting,
+		TrustedSenders: trustedSenders,
+		Strict:         strict,
+		PassphraseEnv:  passphraseEnv,
+		PassphraseFd:   passphraseFd,
+		SSMPassphrase:  ssmPassphrase,
+
+		EndpointUrl:     endpointUrl,
+		PathStyle:       pathStyle,
+		CaBundle:        caBundle,
+		KmsEndpointUrl:  kmsEndpointUrl,
+		VaultAddr:       vaultAddr,
+		VaultTransitKey: vaultTransitKey,
+
+		AzureAccount:          azureAccount,
+		AzureAccountKey:       azureAccountKey,
+		AzureSasToken:         azureSasToken,
+		AzureConnectionString: azureConnectionString,
+
+		SftpKey:        sftpKey,
+		SftpKnownHosts: sftpKnownHosts,
 	}
 
 	debug := viper.GetBool("debug")
@@ -239,11 +480,8 @@ func checkDecryptOptions(options options.Options) {
 	} else if options.Region == "" {
 		log.Warn("Need to supply a region for the S3 bucket.")
 		log.Panic("Insufficient information to perform decryption.")
-	} else if options.PubKey == "" && options.SSMPubKey == "" {
-		log.Warn("Need to supply a public encryption key parameter.")
-		log.Panic("Insufficient information to perform decryption.")
-	} else if options.PrivKey == "" && options.SSMPrivKey == "" {
-		log.Warn("Need to supply a private encryption key parameter.")
+	} else if options.Strict && options.TrustedSenders == "" {
+		log.Warn("Need to supply the trusted senders to verify against in strict mode.")
 		log.Panic("Insufficient information to perform decryption.")
 	}
 }
@@ -264,12 +502,22 @@ func init() {
 	decryptCmd.PersistentFlags().String("aws-profile", "", "AWS profile to use when establishing sessions with AWS's SDK.")
 
 	// ssm keys
-	decryptCmd.PersistentFlags().String("my-private-key", "", "The receiver's private key.  A local file path.")
-	decryptCmd.PersistentFlags().String("my-public-key", "", "The receiver's public key.  A local file path.")
-	decryptCmd.PersistentFlags().String("ssm-private-key", "", "The receiver's private key.  A parameter name in SSM.")
-	decryptCmd.PersistentFlags().String("ssm-public-key", "", "The receiver's public key.  A parameter name in SSM.")
+	decryptCmd.PersistentFlags().String("my-private-key", "", "The receiver's private key or keyring, armored or binary, not needed for batches shared with --kms-key-id or --vault-transit-key.  A local file path or vault://<mount>/<path>#<field> secret.")
+	decryptCmd.PersistentFlags().String("my-public-key", "", "Optional, the public key is read from the private key.  A local file path.")
+	decryptCmd.PersistentFlags().String("ssm-private-key", "", "The receiver's private key or keyring.  A parameter name in SSM.")
+	decryptCmd.PersistentFlags().String("ssm-public-key", "", "Optional, the public key is read from the private key.  A parameter name in SSM.")
+	decryptCmd.PersistentFlags().String("passphrase-env", "", "Name of an environment variable holding the passphrase of a protected private key.")
+	decryptCmd.PersistentFlags().String("passphrase-fd", "", "File descriptor to read the passphrase of a protected private key from, only the first line is read.")
+	decryptCmd.PersistentFlags().String("ssm-passphrase", "", "The passphrase of a protected private key.  A parameter name in SSM.")
+	decryptCmd.PersistentFlags().String("kms-endpoint-url", "", "Send KMS requests to this endpoint instead of the regional one, i.e. a VPC endpoint. Only used for batches shared with --kms-key-id.")
+	decryptCmd.PersistentFlags().String("vault-transit-key", "", "The Vault transit key, given as <mount>/<name>, that unwraps the data keys of batches shared with --vault-transit-key.")
+	decryptCmd.PersistentFlags().String("vault-addr", "", "The Vault server keys given as vault://<mount>/<path>#<field> and --vault-transit-key are read from. Defaults to $VAULT_ADDR, the token is read from $VAULT_TOKEN or ~/.vault-token.")
 	decryptCmd.PersistentFlags().Bool("is-gcs", false, "If the interaction is with gcs.")
 	decryptCmd.PersistentFlags().String("filter-files", "", "list of wildcard files to be only filtered and decrypted")
+	decryptCmd.PersistentFlags().Bool("streaming", false, "If provided, each file is decrypted and unzipped as it downloads without writing .gpg or .zip files.")
+	decryptCmd.PersistentFlags().String("trusted-senders", "", "Public keys of the senders whose signatures are trusted.  A local file path or vault://<mount>/<path>#<field> secret, may contain several armored keys.")
+	decryptCmd.PersistentFlags().Bool("strict", false, "If provided, batches with an unsigned manifest or any file not signed by a trusted sender are refused.")
+	decryptCmd.PersistentFlags().Bool("skip-existing", false, "If provided, files a previous run of this manifest finished decrypting are skipped, as long as the decrypted file is unchanged since.")
 
 	viper.BindPFlag("file", decryptCmd.PersistentFlags().Lookup("file"))
 	viper.BindPFlag("directory", decryptCmd.PersistentFlags().Lookup("directory"))
@@ -279,8 +527,15 @@ func init() {
 	viper.BindPFlag("my-public-key", decryptCmd.PersistentFlags().Lookup("my-public-key"))
 	viper.BindPFlag("ssm-private-key", decryptCmd.PersistentFlags().Lookup("ssm-private-key"))
 	viper.BindPFlag("ssm-public-key", decryptCmd.PersistentFlags().Lookup("ssm-public-key"))
+	viper.BindPFlag("passphrase-env", decryptCmd.PersistentFlags().Lookup("passphrase-env"))
+	viper.BindPFlag("passphrase-fd", decryptCmd.PersistentFlags().Lookup("passphrase-fd"))
+	viper.BindPFlag("ssm-passphrase", decryptCmd.PersistentFlags().Lookup("ssm-passphrase"))
 	viper.BindPFlag("is-gcs", decryptCmd.PersistentFlags().Lookup("is-gcs"))
 	viper.BindPFlag("filter-files", decryptCmd.PersistentFlags().Lookup("filter-files"))
+	viper.BindPFlag("streaming", decryptCmd.PersistentFlags().Lookup("streaming"))
+	viper.BindPFlag("skip-existing", decryptCmd.PersistentFlags().Lookup("skip-existing"))
+	viper.BindPFlag("trusted-senders", decryptCmd.PersistentFlags().Lookup("trusted-senders"))
+	viper.BindPFlag("strict", decryptCmd.PersistentFlags().Lookup("strict"))
 
 	//log.SetFormatter(&log.JSONFormatter{})
 	log.SetFormatter(&log.TextFormatter{})
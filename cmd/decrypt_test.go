@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	file "github.com/tempuslabs/s3s2/file"
+	journal "github.com/tempuslabs/s3s2/journal"
+)
+
+// a decrypted file recorded by a previous run, and the manifest entry share wrote for it
+func decryptedFile(t *testing.T, contents string) (*journal.Progress, file.File, string) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "a.txt")
+	require.NoError(t, os.WriteFile(output, []byte(contents), 0644))
+	info, err := os.Stat(output)
+	require.NoError(t, err)
+	sum, err := file.Sha256(output)
+	require.NoError(t, err)
+
+	progress, err := journal.OpenProgress(filepath.Join(dir, "s3s2_progress.jsonl"))
+	require.NoError(t, err)
+	t.Cleanup(func() { progress.Close() })
+	require.NoError(t, progress.Record("a.txt", output))
+
+	return progress, file.File{Name: "a.txt", Size: info.Size(), ModTime: info.ModTime(), Sha256: sum}, output
+}
+
+// rewrites a decrypted file without changing its size or modification time, which is all the progress file records
+func tamper(t *testing.T, output string, contents string) {
+	info, err := os.Stat(output)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(output, []byte(contents), 0644))
+	require.NoError(t, os.Chtimes(output, time.Now(), info.ModTime()))
+}
+
+func TestAlreadyDecryptedChecksSha256(t *testing.T) {
+	progress, fs, output := decryptedFile(t, "top level file")
+	assert.True(t, alreadyDecrypted(progress, fs, output))
+
+	tamper(t, output, "top level fil3")
+	assert.True(t, progress.Done(fs.Name, output))
+	assert.False(t, alreadyDecrypted(progress, fs, output))
+}
+
+// with a checksum to go by, a file a previous run did not record can be skipped too
+func TestAlreadyDecryptedWithoutProgress(t *testing.T) {
+	_, fs, output := decryptedFile(t, "top level file")
+	progress, err := journal.OpenProgress(filepath.Join(t.TempDir(), "s3s2_progress.jsonl"))
+	require.NoError(t, err)
+	defer progress.Close()
+
+	assert.True(t, alreadyDecrypted(progress, fs, output))
+	fs.Sha256 = ""
+	assert.False(t, alreadyDecrypted(progress, fs, output))
+}
+
+// manifests shared before checksums were recorded can only go by the progress file
+func TestAlreadyDecryptedWithoutChecksum(t *testing.T) {
+	progress, fs, output := decryptedFile(t, "top level file")
+	fs.Sha256 = ""
+	assert.True(t, alreadyDecrypted(progress, fs, output))
+
+	require.NoError(t, os.WriteFile(output, []byte("changed"), 0644))
+	assert.False(t, alreadyDecrypted(progress, fs, output))
+}
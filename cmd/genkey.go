@@ -5,12 +5,18 @@ import (
 	"fmt"
 
 	"github.com/tempuslabs/s3s2/encrypt"
+	"github.com/tempuslabs/s3s2/options"
+	utils "github.com/tempuslabs/s3s2/utils"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 var keydir string
 var keyprefix string
+var algo string
+var protect bool
+var passphraseEnv string
+var passphraseFd string
 
 // genkeyCmd represents the genkey command
 var genkeyCmd = &cobra.Command{
@@ -18,8 +24,15 @@ var genkeyCmd = &cobra.Command{
 	Short: "Generate new gpg keys.",
 	Long:  `Generate new gpg keys.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Printf("Generating new keys with name %s in: %s", keyprefix, keydir)
-		encrypt.GenerateKeys(keydir, keyprefix, 4096)
+		var passphrase []byte
+		// giving a passphrase source implies the key should be protected
+		if protect || passphraseEnv != "" || passphraseFd != "" {
+			var err error
+			passphrase, err = encrypt.GetPassphrase(nil, options.Options{PassphraseEnv: passphraseEnv, PassphraseFd: passphraseFd}, "Passphrase for the new private key", true)
+			utils.PanicIfError("Unable to read passphrase - ", err)
+		}
+		fmt.Printf("Generating new %s keys with name %s in: %s", algo, keyprefix, keydir)
+		encrypt.GenerateKeys(keydir, keyprefix, algo, 4096, passphrase)
 	},
 }
 
@@ -28,6 +41,10 @@ func init() {
 
 	genkeyCmd.PersistentFlags().StringVar(&keydir, "keydir", "", "The directory to write the key files to.")
 	genkeyCmd.PersistentFlags().StringVar(&keyprefix, "keyprefix", "", "The directory to write the key files to.")
+	genkeyCmd.PersistentFlags().StringVar(&algo, "algo", encrypt.AlgoRSA, "The key algorithm. 'rsa' writes a 4096 bit RSA key, 'curve25519' an Ed25519 signing key with a Curve25519 encryption subkey readable by gpg 2.2 and later, 'ed25519' the same pair using the newer RFC 9580 Ed25519 and X25519 algorithms.")
+	genkeyCmd.PersistentFlags().BoolVar(&protect, "protect", false, "Protect the private key with a passphrase, prompted for unless --passphrase-env or --passphrase-fd is given.")
+	genkeyCmd.PersistentFlags().StringVar(&passphraseEnv, "passphrase-env", "", "Name of an environment variable holding the passphrase to protect the private key with.")
+	genkeyCmd.PersistentFlags().StringVar(&passphraseFd, "passphrase-fd", "", "File descriptor to read the passphrase to protect the private key with from, only the first line is read.")
 
 	log.SetFormatter(&log.TextFormatter{})
 	log.SetLevel(log.DebugLevel)
@@ -0,0 +1,318 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	log "github.com/sirupsen/logrus"
+
+	// local
+	encrypt "github.com/tempuslabs/s3s2/encrypt"
+	file "github.com/tempuslabs/s3s2/file"
+	manifest "github.com/tempuslabs/s3s2/manifest"
+	options "github.com/tempuslabs/s3s2/options"
+	storage "github.com/tempuslabs/s3s2/storage"
+	utils "github.com/tempuslabs/s3s2/utils"
+	vault "github.com/tempuslabs/s3s2/vault"
+)
+
+// rekeyCmd represents the rekey command
+var rekeyCmd = &cobra.Command{
+	Use:   "rekey",
+	Short: "Re-encrypt a shared batch to new receiver keys",
+	Long: `Given the manifest of a shared batch, re-encrypt the session key of every object
+    from a retired receiver private key to new receiver public keys. The encrypted
+    contents are left untouched, so nothing is decrypted to disk and sender signatures
+    stay valid.`,
+	// bug in Viper prevents shared flag names across different commands
+	// placing these in the prerun is the workaround
+	PreRun: func(cmd *cobra.Command, args []string) {
+		viper.BindPFlag("parallelism", cmd.Flags().Lookup("parallelism"))
+		viper.BindPFlag("aws-profile", cmd.Flags().Lookup("aws-profile"))
+		viper.BindPFlag("aws-role-arn", cmd.Flags().Lookup("aws-role-arn"))
+		viper.BindPFlag("scratch-directory", cmd.Flags().Lookup("scratch-directory"))
+		viper.BindPFlag("sender-private-key", cmd.Flags().Lookup("sender-private-key"))
+		viper.BindPFlag("ssm-sender-private-key", cmd.Flags().Lookup("ssm-sender-private-key"))
+		viper.BindPFlag("passphrase-env", cmd.Flags().Lookup("passphrase-env"))
+		viper.BindPFlag("passphrase-fd", cmd.Flags().Lookup("passphrase-fd"))
+		viper.BindPFlag("ssm-passphrase", cmd.Flags().Lookup("ssm-passphrase"))
+		viper.BindPFlag("vault-addr", cmd.Flags().Lookup("vault-addr"))
+		cmd.MarkFlagRequired("region")
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+
+		opts := buildRekeyOptions()
+		checkRekeyOptions(opts)
+
+		// top level clients
+		sess := utils.GetAwsSession(opts)
+		_keyring := encrypt.GetPrivKey(sess, opts)
+		_pubkeys := encrypt.GetPubKeys(sess, opts)
+		_signKey := encrypt.GetSignKey(sess, opts)
+
+		backend, err := storage.NewBackend(opts)
+		utils.PanicIfError("Unable to create storage backend - ", err)
+
+		work_dir, err := os.MkdirTemp(opts.ScratchDirectory, "s3s2_rekey")
+		utils.PanicIfError("Unable to create scratch directory - ", err)
+		defer os.RemoveAll(work_dir)
+
+		fn, err := storage.DownloadFile(backend, storage.ObjectKey(opts.Org, opts.File), filepath.Join(work_dir, filepath.Base(opts.File)))
+		utils.PanicIfError("Unable to download manifest - ", err)
+		m := manifest.ReadManifest(fn)
+		if encrypt.Enveloped(m.Format) {
+			log.Panic("Batches shared with envelope encryption have no receiver keys to re-key, rotate the KMS or transit key instead.")
+		}
+
+		// without a new batch folder every object is replaced where it is
+		batch_folder := m.Folder
+		if opts.BatchFolder != "" {
+			batch_folder = opts.BatchFolder
+		}
+		in_place := batch_folder == m.Folder
+
+		// the files of a batch shared with opaque object names are listed in its encrypted manifest
+		file_structs := m.Files
+		if m.Sealed != nil {
+			// rekey has no data keys, openSealedManifest refuses manifests sealed under one
+			file_structs = openSealedManifest(backend, _keyring, nil, nil, m, opts).Files
+		}
+
+		start := time.Now()
+		var wg sync.WaitGroup
+		sem := make(chan int, opts.Parallelism)
+
+		for i, fs := range file_structs {
+			wg.Add(1)
+			go func(wg *sync.WaitGroup, i int, fs file.File) {
+				sem <- 1
+				defer func() { <-sem }()
+				defer wg.Done()
+
+				scratch := filepath.Join(work_dir, fmt.Sprintf("%d", i))
+				// if block is for cases where AWS session expires, backends fetch a fresh session per call so we attempt file again
+				err := rekeyFile(backend, _keyring, _pubkeys, m, fs, batch_folder, in_place, scratch)
+				if err != nil {
+					err = rekeyFile(backend, _keyring, _pubkeys, m, fs, batch_folder, in_place, scratch)
+				}
+				utils.PanicIfError(fmt.Sprintf("Unable to re-key file '%s' - ", fs.Name), err)
+			}(&wg, i, fs)
+		}
+		wg.Wait()
+
+		// re-keyed last, an interrupted run can only be picked up while the old key still opens it
+		if m.Sealed != nil {
+			source_key := storage.ObjectKey(m.Organization, m.Folder, m.Sealed.Name)
+			target_key := storage.ObjectKey(m.Organization, batch_folder, m.Sealed.Name)
+			err = rekeyObject(backend, _keyring, _pubkeys, m.Format, source_key, target_key, in_place, filepath.Join(work_dir, "manifest"))
+			utils.PanicIfError("Unable to re-key encrypted manifest - ", err)
+		}
+
+		// objects replaced in place still match the manifest, apart from the recipients it records.
+		// Those are only updated when that won't leave the sender's signature behind.
+		manifest_key := storage.ObjectKey(opts.Org, opts.File)
+		manifest_bytes, err := os.ReadFile(fn)
+		utils.PanicIfError("Error reading Manifest", err)
+		rewrite := !in_place
+		if in_place && m.Crypto != nil {
+			if _, err := backend.Head(manifest_key + manifest.SignatureSuffix); err != nil || _signKey != nil {
+				rewrite = true
+			} else {
+				log.Warnf("No sender private key given, the signed manifest in '%s' keeps the recipients the batch was shared to", batch_folder)
+			}
+		}
+		if rewrite {
+			if m.Crypto != nil {
+				m.Crypto.Rekey(_keyring, _pubkeys)
+			}
+			if !in_place {
+				m.Folder = batch_folder
+				manifest_key = storage.ObjectKey(m.Organization, batch_folder, m.Name)
+			}
+			manifest_bytes, err = m.Marshal()
+			utils.PanicIfError("Error marshalling Manifest", err)
+			err = backend.PutStream(manifest_key, bytes.NewReader(manifest_bytes))
+			utils.PanicIfError("Error uploading Manifest", err)
+		}
+
+		if _signKey != nil {
+			signature, err := encrypt.SignDetached(_signKey, manifest_bytes)
+			utils.PanicIfError("Error signing Manifest", err)
+			err = backend.PutStream(manifest_key+manifest.SignatureSuffix, bytes.NewReader(signature))
+			utils.PanicIfError("Error uploading Manifest signature", err)
+		} else if !in_place {
+			log.Warnf("No sender private key given, the manifest in '%s' is unsigned", batch_folder)
+		}
+
+		utils.Timing(start, fmt.Sprintf("Re-keyed %d files to '%s' in ", len(file_structs), manifest_key)+"%f seconds")
+	},
+}
+
+// Downloads an object, re-encrypts its session key and uploads it to the batch folder.
+// Objects already re-keyed by an interrupted run are left alone when re-keying in place.
+func rekeyFile(backend storage.Backend, _keyring openpgp.EntityList, _pubkeys openpgp.EntityList, m manifest.Manifest, fs file.File, batch_folder string, in_place bool, scratch string) error {
+	// enforce posix path
+	fs.Name = utils.ToPosixPath(fs.Name)
+	source_key := storage.ObjectKey(m.Organization, fs.GetEncryptedName(m.Folder))
+	target_key := storage.ObjectKey(m.Organization, fs.GetEncryptedName(batch_folder))
+	return rekeyObject(backend, _keyring, _pubkeys, m.Format, source_key, target_key, in_place, scratch)
+}
+
+// Downloads the object at source_key, re-encrypts its session key and uploads it to target_key
+func rekeyObject(backend storage.Backend, _keyring openpgp.EntityList, _pubkeys openpgp.EntityList, format string, source_key string, target_key string, in_place bool, scratch string) error {
+	fn_source, err := storage.DownloadFile(backend, source_key, scratch+".gpg")
+	if err != nil {
+		return err
+	}
+	defer utils.CleanupFile(fn_source)
+
+	in, err := os.Open(fn_source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fn_rekeyed := scratch + ".rekeyed.gpg"
+	out, err := os.Create(fn_rekeyed)
+	if err != nil {
+		return err
+	}
+	defer utils.CleanupFile(fn_rekeyed)
+	defer out.Close()
+
+	// empty objects are skipped by decrypt, there is nothing to re-key
+	rekeyed := false
+	if info, err := in.Stat(); err == nil && info.Size() == 0 {
+		log.Warningf("Downloaded file '%s' is empty", source_key)
+	} else if rekeyed, err = encrypt.RekeyMessage(_keyring, _pubkeys, in, out, format); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+
+	if in_place && !rekeyed {
+		log.Infof("Skipping '%s' - already encrypted to the new keys", source_key)
+		return nil
+	}
+	log.Debugf("Re-keyed file '%s' to '%s'", source_key, target_key)
+	return backend.Put(target_key, fn_rekeyed)
+}
+
+func buildRekeyOptions() options.Options {
+	scratch_directory := viper.GetString("scratch-directory")
+	if scratch_directory != "" {
+		scratch_directory = filepath.Clean(scratch_directory)
+	}
+	batch_folder := viper.GetString("batch-folder")
+	if batch_folder != "" {
+		batch_folder = utils.ToPosixPath(filepath.Clean(batch_folder))
+	}
+
+	// the same comma-separated lists share takes for its receivers
+	var pub_keys []string
+	for _, pub_key := range utils.SplitList(viper.GetString("new-public-key")) {
+		if !vault.IsRef(pub_key) && !encrypt.IsKeyURL(pub_key) {
+			pub_key = filepath.Clean(pub_key)
+		}
+		pub_keys = append(pub_keys, pub_key)
+	}
+
+	options := options.Options{
+		Bucket:      viper.GetString("bucket"),
+		Region:      viper.GetString("region"),
+		AwsProfile:  viper.GetString("aws-profile"),
+		AwsRoleArn:  viper.GetString("aws-role-arn"),
+		Parallelism: viper.GetInt("parallelism"),
+		File:        viper.GetString("manifest"),
+		BatchFolder: batch_folder,
+
+		ScratchDirectory: scratch_directory,
+		PrivKey:          viper.GetString("old-private-key"),
+		SSMPrivKey:       viper.GetString("ssm-old-private-key"),
+		PubKey:           strings.Join(pub_keys, ","),
+		SSMPubKey:        viper.GetString("ssm-new-public-key"),
+		ReceiverKeyFingerprint: viper.GetString("new-key-fingerprint"),
+		SignKey:          viper.GetString("sender-private-key"),
+		SSMSignKey:       viper.GetString("ssm-sender-private-key"),
+		PassphraseEnv:    viper.GetString("passphrase-env"),
+		PassphraseFd:     viper.GetString("passphrase-fd"),
+		SSMPassphrase:    viper.GetString("ssm-passphrase"),
+		VaultAddr:        viper.GetString("vault-addr"),
+
+		EndpointUrl: viper.GetString("endpoint-url"),
+		PathStyle:   viper.GetBool("path-style"),
+		CaBundle:    viper.GetString("ca-bundle"),
+
+		AzureAccount:          viper.GetString("azure-account"),
+		AzureAccountKey:       viper.GetString("azure-account-key"),
+		AzureSasToken:         viper.GetString("azure-sas-token"),
+		AzureConnectionString: viper.GetString("azure-connection-string"),
+
+		SftpKey:        viper.GetString("sftp-key"),
+		SftpKnownHosts: viper.GetString("sftp-known-hosts"),
+	}
+
+	log.Debugf("Captured options: %+v", options)
+	return options
+}
+
+func checkRekeyOptions(options options.Options) {
+	if options.File == "" {
+		log.Panic("Need to supply the manifest of the batch to re-key. Should be the path within the bucket but not including the bucket.")
+	} else if options.Bucket == "" {
+		log.Panic("Need to supply a bucket.")
+	} else if options.PrivKey == "" && options.SSMPrivKey == "" {
+		log.Panic("Need to supply the old private key the batch is encrypted to.")
+	} else if options.PubKey == "" && options.SSMPubKey == "" {
+		log.Panic("Need to supply the new public keys to encrypt the batch to.")
+	} else if options.Parallelism < 1 {
+		log.Panic("Parallelism must be at least 1.")
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(rekeyCmd)
+
+	// core flags
+	rekeyCmd.PersistentFlags().String("manifest", "", "The path to the manifest of the batch to re-key.")
+	rekeyCmd.MarkFlagRequired("manifest")
+	rekeyCmd.PersistentFlags().String("batch-folder", "", "If provided, the re-keyed batch and its manifest are uploaded to this folder instead of replacing the objects in place.")
+	rekeyCmd.PersistentFlags().String("scratch-directory", "", "Where each object is held while it is re-keyed. Defaults to the system temp directory.")
+
+	// technical configuration
+	rekeyCmd.PersistentFlags().Int("parallelism", 10, "The maximum number of files to re-key at a time.")
+	rekeyCmd.PersistentFlags().String("aws-profile", "", "AWS profile to use when establishing sessions with AWS's SDK.")
+	rekeyCmd.PersistentFlags().String("aws-role-arn", "", "AWS Role ARN to assume for the session.")
+
+	// keys
+	rekeyCmd.PersistentFlags().String("old-private-key", "", "The retired receiver private key or keyring the batch is encrypted to.  A local file path or vault://<mount>/<path>#<field> secret.")
+	rekeyCmd.PersistentFlags().String("ssm-old-private-key", "", "The retired receiver private key or keyring the batch is encrypted to.  A parameter name in SSM.")
+	rekeyCmd.PersistentFlags().String("new-public-key", "", "The receivers' new public keys, every file is re-encrypted to each of them.  Comma-separated local file paths, https urls or vault://<mount>/<path>#<field> secrets.")
+	rekeyCmd.PersistentFlags().String("new-key-fingerprint", "", "Required for new keys fetched from a url, any key served there whose fingerprint is not listed is refused.  Comma-separated fingerprints as printed by gpg.")
+	rekeyCmd.PersistentFlags().String("ssm-new-public-key", "", "The receivers' new public keys, every file is re-encrypted to each of them.  Comma-separated parameter names in SSM.")
+	rekeyCmd.PersistentFlags().String("sender-private-key", "", "If provided, the manifest is signed again with this key.  A local file path.")
+	rekeyCmd.PersistentFlags().String("ssm-sender-private-key", "", "If provided, the manifest is signed again with this key.  A parameter name in SSM.")
+	rekeyCmd.PersistentFlags().String("passphrase-env", "", "Name of an environment variable holding the passphrase of the protected private keys.")
+	rekeyCmd.PersistentFlags().String("passphrase-fd", "", "File descriptor to read the passphrase of the protected private keys from, only the first line is read.")
+	rekeyCmd.PersistentFlags().String("ssm-passphrase", "", "The passphrase of the protected private keys.  A parameter name in SSM.")
+	rekeyCmd.PersistentFlags().String("vault-addr", "", "The Vault server keys given as vault://<mount>/<path>#<field> are read from. Defaults to $VAULT_ADDR, the token is read from $VAULT_TOKEN or ~/.vault-token.")
+
+	viper.BindPFlag("manifest", rekeyCmd.PersistentFlags().Lookup("manifest"))
+	viper.BindPFlag("batch-folder", rekeyCmd.PersistentFlags().Lookup("batch-folder"))
+	viper.BindPFlag("old-private-key", rekeyCmd.PersistentFlags().Lookup("old-private-key"))
+	viper.BindPFlag("ssm-old-private-key", rekeyCmd.PersistentFlags().Lookup("ssm-old-private-key"))
+	viper.BindPFlag("new-public-key", rekeyCmd.PersistentFlags().Lookup("new-public-key"))
+	viper.BindPFlag("ssm-new-public-key", rekeyCmd.PersistentFlags().Lookup("ssm-new-public-key"))
+	viper.BindPFlag("new-key-fingerprint", rekeyCmd.PersistentFlags().Lookup("new-key-fingerprint"))
+}
@@ -49,12 +49,30 @@ func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.s3s2.yaml)")
 	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "debug mode")
-	rootCmd.PersistentFlags().StringVar(&bucket, "bucket", "", "The bucket to work with.")
+	rootCmd.PersistentFlags().StringVar(&bucket, "bucket", "", "The bucket to work with. A bare name is an S3 bucket, or a url such as s3://bucket/prefix or gs://bucket/prefix selects the storage backend.")
 	rootCmd.PersistentFlags().StringVar(&region, "region", "", "The region the bucket is in.")
+	rootCmd.PersistentFlags().String("endpoint-url", "", "Send S3 requests to this endpoint instead of AWS, i.e. a MinIO or Ceph RGW url.")
+	rootCmd.PersistentFlags().Bool("path-style", false, "Use path-style S3 addressing (endpoint/bucket/key), required by most S3-compatible object stores.")
+	rootCmd.PersistentFlags().String("ca-bundle", "", "A PEM file of CA certificates to trust when connecting to AWS, an S3-compatible endpoint or a url receiver keys are fetched from.")
+	rootCmd.PersistentFlags().String("azure-account", "", "The Azure storage account of an az:// destination. Defaults to $AZURE_STORAGE_ACCOUNT.")
+	rootCmd.PersistentFlags().String("azure-account-key", "", "Shared key for the Azure storage account. Defaults to $AZURE_STORAGE_KEY.")
+	rootCmd.PersistentFlags().String("azure-sas-token", "", "SAS token for the Azure storage account. Defaults to $AZURE_STORAGE_SAS_TOKEN.")
+	rootCmd.PersistentFlags().String("azure-connection-string", "", "Connection string for the Azure storage account, takes precedence over the other Azure options. Defaults to $AZURE_STORAGE_CONNECTION_STRING.")
+	rootCmd.PersistentFlags().String("sftp-key", "", "The ssh private key used to authenticate to an sftp:// destination. Defaults to ~/.ssh/id_rsa.")
+	rootCmd.PersistentFlags().String("sftp-known-hosts", "", "The known_hosts file the sftp server's host key must be listed in. Defaults to ~/.ssh/known_hosts.")
 
 	viper.BindPFlag("bucket", rootCmd.PersistentFlags().Lookup("bucket"))
 	viper.BindPFlag("region", rootCmd.PersistentFlags().Lookup("region"))
 	viper.BindPFlag("debug", rootCmd.PersistentFlags().Lookup("debug"))
+	viper.BindPFlag("endpoint-url", rootCmd.PersistentFlags().Lookup("endpoint-url"))
+	viper.BindPFlag("path-style", rootCmd.PersistentFlags().Lookup("path-style"))
+	viper.BindPFlag("ca-bundle", rootCmd.PersistentFlags().Lookup("ca-bundle"))
+	viper.BindPFlag("azure-account", rootCmd.PersistentFlags().Lookup("azure-account"))
+	viper.BindPFlag("azure-account-key", rootCmd.PersistentFlags().Lookup("azure-account-key"))
+	viper.BindPFlag("azure-sas-token", rootCmd.PersistentFlags().Lookup("azure-sas-token"))
+	viper.BindPFlag("azure-connection-string", rootCmd.PersistentFlags().Lookup("azure-connection-string"))
+	viper.BindPFlag("sftp-key", rootCmd.PersistentFlags().Lookup("sftp-key"))
+	viper.BindPFlag("sftp-known-hosts", rootCmd.PersistentFlags().Lookup("sftp-known-hosts"))
 
 }
 
@@ -1,7 +1,9 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,19 +12,20 @@ import (
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
-	"golang.org/x/crypto/openpgp/packet"
-
-	session "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/ProtonMail/go-crypto/openpgp"
 
 	log "github.com/sirupsen/logrus"
 
 	// local
-	aws_helpers "github.com/tempuslabs/s3s2/aws_helpers"
+	compress "github.com/tempuslabs/s3s2/compress"
 	encrypt "github.com/tempuslabs/s3s2/encrypt"
 	file "github.com/tempuslabs/s3s2/file"
+	journal "github.com/tempuslabs/s3s2/journal"
 	manifest "github.com/tempuslabs/s3s2/manifest"
 	options "github.com/tempuslabs/s3s2/options"
+	storage "github.com/tempuslabs/s3s2/storage"
 	utils "github.com/tempuslabs/s3s2/utils"
+	vault "github.com/tempuslabs/s3s2/vault"
 	zip "github.com/tempuslabs/s3s2/zip"
 
 	federated_identity "github.com/tempuslabs/s3s2/federated_identity"
@@ -46,7 +49,17 @@ var shareCmd = &cobra.Command{
 		viper.BindPFlag("ssm-public-key", cmd.Flags().Lookup("ssm-public-key"))
 		viper.BindPFlag("is-gcs", cmd.Flags().Lookup("is-gcs"))
 		viper.BindPFlag("share-from-list", cmd.Flags().Lookup("share-from-list"))
+		viper.BindPFlag("streaming", cmd.Flags().Lookup("streaming"))
 		viper.BindPFlag("aws-role-arn", cmd.Flags().Lookup("aws-role-arn"))
+		viper.BindPFlag("scratch-directory", cmd.Flags().Lookup("scratch-directory"))
+		viper.BindPFlag("kms-endpoint-url", cmd.Flags().Lookup("kms-endpoint-url"))
+		viper.BindPFlag("vault-addr", cmd.Flags().Lookup("vault-addr"))
+		viper.BindPFlag("vault-transit-key", cmd.Flags().Lookup("vault-transit-key"))
+		viper.BindPFlag("sender-private-key", cmd.Flags().Lookup("sender-private-key"))
+		viper.BindPFlag("ssm-sender-private-key", cmd.Flags().Lookup("ssm-sender-private-key"))
+		viper.BindPFlag("passphrase-env", cmd.Flags().Lookup("passphrase-env"))
+		viper.BindPFlag("passphrase-fd", cmd.Flags().Lookup("passphrase-fd"))
+		viper.BindPFlag("ssm-passphrase", cmd.Flags().Lookup("ssm-passphrase"))
 		cmd.MarkFlagRequired("org")
 		cmd.MarkFlagRequired("region")
 	},
@@ -59,9 +72,21 @@ var shareCmd = &cobra.Command{
         fnuuid := start.Format("20060102150405") // golang uses numeric constants for timestamp formatting
         date_folder := start.Format("20060102")  // required for sharing from list
 
+        // a resumed run continues writing to the batch folders of the run it picks up from
+        var jrnl *journal.Journal
+        var err error
+        if opts.Resume != "" {
+            jrnl = resumeJournal(opts)
+            if jrnl.Complete {
+                log.Infof("Journal '%s' records a completed share, nothing to resume", opts.Resume)
+                return
+            }
+            fnuuid = jrnl.Header.Fnuuid
+            date_folder = jrnl.Header.DateFolder
+        }
+
         var file_structs []file.File
         var file_structs_metadata []file.File
-        var err error
         if opts.Directory != "" {
             file_structs, file_structs_metadata, err = file.GetFileStructsFromDir(opts.Directory, opts)
             utils.PanicIfError("Error reading directory", err)
@@ -76,7 +101,16 @@ var shareCmd = &cobra.Command{
 		    panic("No files from input directory were read. This means the directory is empty or only contains invalid files.")
 		}
 
-		file_struct_chunks := append([][]file.File{file_structs_metadata}, file.ChunkArray(file_structs, opts.ChunkSize)...)
+		// files the interrupted run already uploaded are not sent again, metadata files only need to be in the current batch
+		pending_files, already_uploaded := jrnl.Split(file_structs)
+		var pending_metadata []file.File
+		for _, mdf := range file_structs_metadata {
+		    if opts.Resume == "" || !jrnl.Has(jrnl.CurrentBatch(), mdf.Name) {
+		        pending_metadata = append(pending_metadata, mdf)
+		    }
+		}
+
+		file_struct_chunks := append([][]file.File{pending_metadata}, file.ChunkArray(pending_files, opts.ChunkSize)...)
 
 	    var work_folder string
         if opts.ScratchDirectory != "" {
@@ -86,7 +120,19 @@ var shareCmd = &cobra.Command{
         }
 
         sess := utils.GetAwsSession(opts)
-	    _pubKey := encrypt.GetPubKey(sess, opts)
+	    // in envelope mode each file is sealed under a data key of its own, there are no receiver public keys
+	    var _pubKeys openpgp.EntityList
+	    _dataKeys, err := encrypt.NewDataKeys(sess, encrypt.OutputFormat(opts), opts)
+	    utils.PanicIfError("Unable to set up data keys - ", err)
+	    if _dataKeys == nil {
+	        _pubKeys = encrypt.GetPubKeys(sess, opts)
+	    }
+	    _signKey := encrypt.GetSignKey(sess, opts)
+	    // recorded in every manifest so decrypt can check its keys before downloading anything
+	    crypto := manifest.NewCrypto(_pubKeys, _signKey, versionString, opts)
+
+	    backend, err := storage.NewBackend(opts)
+	    utils.PanicIfError("Unable to create storage backend - ", err)
 
 	    sem := make(chan int, opts.Parallelism)
 
@@ -101,6 +147,29 @@ var shareCmd = &cobra.Command{
 
 		batch_folder = fmt.Sprintf("%s_s3s2_%s_%d", opts.Prefix, fnuuid, current_s3_batch)
 
+		if opts.Resume != "" {
+		    batch_folder = jrnl.CurrentBatch()
+		    current_s3_batch = len(jrnl.Batches) - 1
+		    all_uploaded_files_so_far = append(all_uploaded_files_so_far, jrnl.Uploaded[batch_folder]...)
+		    current_s3_folder_size = len(all_uploaded_files_so_far)
+		    log.Infof("Resuming share into '%s' - skipping %d files already uploaded", batch_folder, len(already_uploaded))
+
+		    // batches tied off before the interruption may not have had their trigger sent
+		    for _, previous_batch := range jrnl.Batches[:current_s3_batch] {
+		        if opts.LambdaTrigger == true && !jrnl.Triggered[previous_batch] {
+		            err = storage.UploadLambdaTrigger(backend, opts.Org, previous_batch)
+		            utils.PanicIfError("Error uploading lambda trigger - ", err)
+		            err = jrnl.RecordTrigger(previous_batch)
+		            utils.PanicIfError("Error writing journal - ", err)
+		        }
+		    }
+		} else {
+		    jrnl = createJournal(opts, fnuuid, date_folder)
+		    err = jrnl.StartBatch(batch_folder)
+		    utils.PanicIfError("Error writing journal - ", err)
+		}
+		defer jrnl.Close()
+
         // for each chunk
 		for i_chunk, chunk := range file_struct_chunks {
 
@@ -121,23 +190,35 @@ var shareCmd = &cobra.Command{
             // this is used to create digestable folders for decrypt
 		    if current_s3_folder_size + len(chunk) > change_s3_folders_at_size {
 
-                // fire lambda for the batch we are tieing off
-		        if opts.LambdaTrigger == true {
-		            aws_helpers.UploadLambdaTrigger(sess, opts.Org, batch_folder, opts)
-		        }
+		        previous_batch_folder := batch_folder
 
                 // reset / increment variables
 		        current_s3_folder_size = 0
 		        current_s3_batch += 1
 		        batch_folder = fmt.Sprintf("%s_s3s2_%s_%d", opts.Prefix, fnuuid, current_s3_batch)
 
+		        // journal the new batch before the trigger so a resumed run never adds to a batch that was tied off
+		        err = jrnl.StartBatch(batch_folder)
+		        utils.PanicIfError("Error writing journal - ", err)
+
+                // fire lambda for the batch we are tieing off
+		        if opts.LambdaTrigger == true {
+		            err = storage.UploadLambdaTrigger(backend, opts.Org, previous_batch_folder)
+		            utils.PanicIfError("Error uploading lambda trigger - ", err)
+		            err = jrnl.RecordTrigger(previous_batch_folder)
+		            utils.PanicIfError("Error writing journal - ", err)
+		        }
+
                 // ensure the new s3 folder also has the metadata files
-                for _, mdf := range file_structs_metadata {
+                for i_mdf, mdf := range file_structs_metadata {
                     if opts.Directory != "" {
-                        processFile(sess, _pubKey, batch_folder, work_folder, mdf, opts)
+                        mdf = processFile(backend, _pubKeys, _signKey, _dataKeys, batch_folder, work_folder, mdf, opts)
                     } else {
-                        processFileInMemory(sess, _pubKey, batch_folder, work_folder, mdf, date_folder, opts)
+                        mdf = processFileFromList(backend, _pubKeys, _signKey, _dataKeys, batch_folder, mdf, date_folder, opts)
                     }
+                    file_structs_metadata[i_mdf] = mdf
+                    err = jrnl.RecordUpload(batch_folder, mdf)
+                    utils.PanicIfError("Error writing journal - ", err)
                     current_s3_folder_size += 1
                 }
 
@@ -148,17 +229,21 @@ var shareCmd = &cobra.Command{
             wg.Add(len(chunk))
 
             // for each file in chunk
-            for _, fs := range chunk {
-                go func(wg *sync.WaitGroup, sess *session.Session, _pubkey *packet.PublicKey, folder string, fs file.File, opts options.Options) {
+            for i_file, fs := range chunk {
+                go func(wg *sync.WaitGroup, backend storage.Backend, _pubkeys openpgp.EntityList, _signkey *openpgp.Entity, folder string, fs file.File, opts options.Options) {
                     sem <- 1
                     defer func() { <-sem }()
                     defer wg.Done()
                     if opts.Directory != "" {
-                        processFile(sess, _pubKey, batch_folder, work_folder, fs, opts)
+                        fs = processFile(backend, _pubKeys, _signKey, _dataKeys, batch_folder, work_folder, fs, opts)
                     } else {
-                        processFileInMemory(sess, _pubKey, batch_folder, work_folder, fs, date_folder, opts)
+                        fs = processFileFromList(backend, _pubKeys, _signKey, _dataKeys, batch_folder, fs, date_folder, opts)
                     }
-                }(&wg, sess, _pubKey, batch_folder, fs, opts)
+                    // keep the recorded size and checksum for the manifest
+                    chunk[i_file] = fs
+                    err := jrnl.RecordUpload(batch_folder, fs)
+                    utils.PanicIfError("Error writing journal - ", err)
+                }(&wg, backend, _pubKeys, _signKey, batch_folder, fs, opts)
             }
 
             wg.Wait()
@@ -171,20 +256,41 @@ var shareCmd = &cobra.Command{
             if opts.Directory == "" {
                 for _, fs := range all_uploaded_files_so_far {
                     _, file_name := filepath.Split(fs.Name)
-                    all_uploaded_files_in_batch = append(all_uploaded_files_in_batch, file.File{Name: filepath.Join(date_folder, file_name)})
+                    fs.Name = filepath.Join(date_folder, file_name)
+                    all_uploaded_files_in_batch = append(all_uploaded_files_in_batch, fs)
                 }
             } else {
                 all_uploaded_files_in_batch = all_uploaded_files_so_far
             }
             // upon chunk completion
-            m, err = manifest.BuildManifest(all_uploaded_files_in_batch, batch_folder, opts)
+            m, err = manifest.BuildManifest(all_uploaded_files_in_batch, batch_folder, crypto, opts)
             utils.PanicIfError("Error building Manifest", err)
 
             // create manifest in top-level directory - overwrite any existing manifest to include latest chunk
             manifest_aws_key := filepath.Join(batch_folder, m.Name)
-            manifest_local := filepath.Join(opts.Directory, m.Name)
-            err = aws_helpers.UploadFile(sess, opts.Org, manifest_aws_key, manifest_local, opts)
-            utils.PanicIfError("Error uploading Manifest", err)
+            manifest_bytes, err := m.Marshal()
+            utils.PanicIfError("Error marshalling Manifest", err)
+            if opts.OpaqueNames {
+                // only the encrypted manifest names the files, the one in the clear points to it
+                manifest_bytes = uploadSealedManifest(backend, _pubKeys, _signKey, _dataKeys, m, manifest_bytes, opts)
+                err = backend.PutStream(storage.ObjectKey(opts.Org, manifest_aws_key), bytes.NewReader(manifest_bytes))
+                utils.PanicIfError("Error uploading Manifest", err)
+            } else if opts.Streaming {
+                err = backend.PutStream(storage.ObjectKey(opts.Org, manifest_aws_key), bytes.NewReader(manifest_bytes))
+                utils.PanicIfError("Error uploading Manifest", err)
+            } else {
+                manifest_local := filepath.Join(opts.Directory, m.Name)
+                err = backend.Put(storage.ObjectKey(opts.Org, manifest_aws_key), manifest_local)
+                utils.PanicIfError("Error uploading Manifest", err)
+            }
+
+            // a detached signature next to the manifest lets the receiver check who produced the batch
+            if _signKey != nil {
+                signature, err := encrypt.SignDetached(_signKey, manifest_bytes)
+                utils.PanicIfError("Error signing Manifest", err)
+                err = backend.PutStream(storage.ObjectKey(opts.Org, manifest_aws_key+manifest.SignatureSuffix), bytes.NewReader(signature))
+                utils.PanicIfError("Error uploading Manifest signature", err)
+            }
 
             // archive the files we processed in this batch, dont archive metadata files until entire process is done
             if opts.ArchiveDirectory != "" && i_chunk != 0 {
@@ -195,9 +301,9 @@ var shareCmd = &cobra.Command{
             log.Debugf("Successfully processed chunk '%d'", i_chunk)
 
         }
-        // archive metafiles now
+        // archive metafiles now, along with any files the interrupted run uploaded but had not archived yet
         if opts.ArchiveDirectory != "" {
-            file.ArchiveFileStructs(file_structs_metadata, opts.Directory, opts.ArchiveDirectory)
+            file.ArchiveFileStructs(append(already_uploaded, file_structs_metadata...), opts.Directory, opts.ArchiveDirectory)
         }
 
         if opts.DeleteOnCompletion == true {
@@ -210,24 +316,98 @@ var shareCmd = &cobra.Command{
 
 		utils.Timing(start, "Elapsed time: %f")
         if opts.LambdaTrigger == true {
-            aws_helpers.UploadLambdaTrigger(sess, opts.Org, batch_folder, opts)
+            err = storage.UploadLambdaTrigger(backend, opts.Org, batch_folder)
+            utils.PanicIfError("Error uploading lambda trigger - ", err)
+            err = jrnl.RecordTrigger(batch_folder)
+            utils.PanicIfError("Error writing journal - ", err)
         }
+
+        err = jrnl.RecordComplete()
+        utils.PanicIfError("Error writing journal - ", err)
     },
 }
 
-func processFile(sess *session.Session, _pubkey *packet.PublicKey, aws_folder string, work_folder string, fs file.File, opts options.Options) {
+// The journal lives in the scratch directory, or the archive directory when there is no scratch directory.
+// Without either there is nowhere safe to keep it, so the run cannot be resumed.
+func createJournal(opts options.Options, fnuuid string, date_folder string) *journal.Journal {
+    journal_dir := opts.ScratchDirectory
+    if journal_dir == "" {
+        journal_dir = opts.ArchiveDirectory
+    }
+    if journal_dir == "" {
+        log.Info("No scratch or archive directory provided - this share will not be resumable")
+        return nil
+    }
+
+    err := os.MkdirAll(journal_dir, os.ModePerm)
+    utils.PanicIfError("Unable to create journal directory - ", err)
+
+    journal_path := filepath.Join(journal_dir, fmt.Sprintf("s3s2_journal_%s.jsonl", fnuuid))
+    jrnl, err := journal.Create(journal_path, fnuuid, date_folder, opts)
+    utils.PanicIfError("Unable to create journal - ", err)
+
+    log.Infof("Recording progress to '%s' - if this share fails, rerun it with '--resume %s'", journal_path, journal_path)
+    return jrnl
+}
+
+// A run can only be resumed with the same inputs and destination folders it was started with
+func resumeJournal(opts options.Options) *journal.Journal {
+    jrnl, err := journal.Open(opts.Resume)
+    utils.PanicIfError("Unable to read journal - ", err)
+
+    started := jrnl.Header
+    if started.Org != opts.Org || started.Prefix != opts.Prefix || started.Directory != opts.Directory || started.ShareFromList != opts.ShareFromList {
+        panic(fmt.Sprintf("Journal '%s' was recorded for org '%s', prefix '%s', directory '%s' and list '%s' - resume with the same arguments.",
+            opts.Resume, started.Org, started.Prefix, started.Directory, started.ShareFromList))
+    }
+    // a batch is either all armored or all binary, the manifest records one format for it
+    if started.Binary != opts.Binary {
+        panic(fmt.Sprintf("Journal '%s' was recorded with --binary=%t - resume with the same output format.", opts.Resume, started.Binary))
+    }
+    if started.KmsKeyId != opts.KmsKeyId {
+        panic(fmt.Sprintf("Journal '%s' was recorded with --kms-key-id '%s' - resume with the same KMS key.", opts.Resume, started.KmsKeyId))
+    }
+    if started.VaultTransitKey != opts.VaultTransitKey {
+        panic(fmt.Sprintf("Journal '%s' was recorded with --vault-transit-key '%s' - resume with the same transit key.", opts.Resume, started.VaultTransitKey))
+    }
+    if started.OpaqueNames != opts.OpaqueNames {
+        panic(fmt.Sprintf("Journal '%s' was recorded with --opaque-names=%t - resume with the same object names.", opts.Resume, started.OpaqueNames))
+    }
+    return jrnl
+}
+
+// Returns the file with its source size, modification time and checksum recorded for the manifest
+func processFile(backend storage.Backend, _pubkeys openpgp.EntityList, _signkey *openpgp.Entity, _datakeys encrypt.DataKeys, aws_folder string, work_folder string, fs file.File, opts options.Options) file.File {
+	fn_source := fs.GetSourceName(opts.Directory)
+	fs.Compression = compress.Choose(fn_source, opts)
+	data_key := newDataKey(_datakeys, &fs)
+	newObjectKey(&fs, opts)
+
+	// the source is read once, its size and checksum are recorded from what is zipped
+	source, err := fs.Open(fn_source, opts.Hash)
+	utils.PanicIfError("Unable to read source file - ", err)
+
+	if opts.Streaming {
+		processFileStreaming(backend, _pubkeys, _signkey, data_key, aws_folder, source, fs, opts)
+		return fs
+	}
+
 	log.Debugf("Processing file '%s'", fs.Name)
 	start := time.Now()
 
-	fn_source := fs.GetSourceName(opts.Directory)
 	fn_zip := fs.GetZipName(work_folder)
 	fn_encrypt := fs.GetEncryptedName(work_folder)
 	fn_aws_key := fs.GetEncryptedName(aws_folder)
 
-	zip.ZipFile(fn_source, fn_zip, work_folder)
-	encrypt.EncryptFile(_pubkey, fn_zip, fn_encrypt, opts)
+	err = zipSource(source, strings.Replace(fn_source, work_folder, "", -1), fn_zip)
+	utils.PanicIfError("Unable to zip source file - ", err)
+	if data_key != nil {
+		encrypt.EnvelopeEncryptFile(data_key, fn_zip, fn_encrypt, fs.Compression, opts)
+	} else {
+		encrypt.EncryptFile(_pubkeys, _signkey, fn_zip, fn_encrypt, fs.Compression, opts)
+	}
 
-	err := aws_helpers.UploadFile(sess, opts.Org, fn_aws_key, fn_encrypt, opts)
+	err = backend.Put(storage.ObjectKey(opts.Org, fn_aws_key), fn_encrypt)
 
 	if err != nil {
 	    utils.PanicIfError("Error uploading file - ", err)
@@ -242,34 +422,146 @@ func processFile(sess *session.Session, _pubkey *packet.PublicKey, aws_folder st
     // these file names are often /internal_dir/basename
     // this line is a non-performant way for each file to be responsible for cleaning up the directory they were in
 	if opts.ScratchDirectory != "" {
-        nested_dir_crypt, _ := filepath.Split(fn_encrypt)
+        nested_dir_crypt, _ := filepath.Split(fn_zip)
         source_dir_empty, _ := utils.IsDirEmpty(nested_dir_crypt)
 
         if source_dir_empty == true {
             os.Remove(nested_dir_crypt)
         }
     }
+    return fs
+}
+
+// Zips the source into fn_zip under name, closing the source so its size and checksum are recorded
+func zipSource(source *file.Source, name string, fn_zip string) error {
+	os.MkdirAll(filepath.Dir(fn_zip), os.ModePerm)
+	out, err := os.Create(fn_zip)
+	if err != nil {
+		source.Close()
+		return err
+	}
+
+	info, _ := source.Stat()
+	err = zip.ZipReader(source, info, name, out)
+	if close_err := source.Close(); err == nil {
+		err = close_err
+	}
+	if close_err := out.Close(); err == nil {
+		err = close_err
+	}
+	return err
 }
 
-func processFileInMemory(sess *session.Session, _pubkey *packet.PublicKey, aws_folder string, work_folder string, fs file.File, date_folder string, opts options.Options) {
-    log.Debugf("Processing file '%s'", fs.Name)
-    start := time.Now()
+// Source file is zipped and encrypted on the fly and piped into the upload - no .zip or .gpg touches the disk
+func processFileStreaming(backend storage.Backend, _pubkeys openpgp.EntityList, _signkey *openpgp.Entity, data_key []byte, aws_folder string, source *file.Source, fs file.File, opts options.Options) {
+	fn_aws_key := fs.GetEncryptedName(aws_folder)
 
-    fn_source := fs.Name
-    _, file_name := filepath.Split(fn_source)
-    fn_aws_key := filepath.Join(aws_folder, date_folder, file_name+".zip.gpg")
+	streamFile(backend, _pubkeys, _signkey, data_key, source, fs.GetSourceName(opts.Directory), fs.Name, fn_aws_key, fs.Compression, opts)
+}
 
-    fn_zip := zip.ZipFileInMemory(fn_source, date_folder)
+// Files shared from a list are streamed the same way, the backend's memory ceiling bounds what all workers buffer
+func processFileFromList(backend storage.Backend, _pubkeys openpgp.EntityList, _signkey *openpgp.Entity, _datakeys encrypt.DataKeys, aws_folder string, fs file.File, date_folder string, opts options.Options) file.File {
+	fs.Compression = compress.Choose(fs.Name, opts)
+	data_key := newDataKey(_datakeys, &fs)
+	newObjectKey(&fs, opts)
 
-    fn_encrypted := encrypt.EncryptBuffer(_pubkey, fn_zip, opts)
+	source, err := fs.Open(fs.Name, opts.Hash)
+	utils.PanicIfError("Unable to read source file - ", err)
 
-    err := aws_helpers.UploadBuffer(sess, opts.Org, fn_aws_key, fn_encrypted, file_name, opts)
+	_, file_name := filepath.Split(fs.Name)
+	zip_name := filepath.Join(date_folder, file_name)
+	fn_aws_key := filepath.Join(aws_folder, zip_name+".zip.gpg")
+	if fs.Key != "" {
+		fn_aws_key = fs.GetEncryptedName(aws_folder)
+	}
 
-    if err != nil {
-        utils.PanicIfError("Error uploading file - ", err)
-    } else {
-        utils.Timing(start, fmt.Sprintf("\tProcessed file '%s' in ", file_name)+"%f seconds")
-    }
+	streamFile(backend, _pubkeys, _signkey, data_key, source, fs.Name, zip_name, fn_aws_key, fs.Compression, opts)
+	return fs
+}
+
+// The source is closed once it has been zipped, so its size and checksum are recorded by the time the upload returns
+func streamFile(backend storage.Backend, _pubkeys openpgp.EntityList, _signkey *openpgp.Entity, data_key []byte, source *file.Source, fn_source string, zip_name string, fn_aws_key string, codec string, opts options.Options) {
+	log.Debugf("Streaming file '%s'", fn_source)
+	start := time.Now()
+
+	reader, writer := io.Pipe()
+
+	go func() {
+		encrypted, err := newObjectWriter(_pubkeys, _signkey, data_key, writer, codec, opts)
+		if err == nil {
+			info, _ := source.Stat()
+			err = zip.ZipReader(source, info, zip_name, encrypted)
+		}
+		if err == nil {
+			err = encrypted.Close()
+		}
+		if close_err := source.Close(); err == nil {
+			err = close_err
+		}
+		writer.CloseWithError(err)
+	}()
+
+	err := backend.PutStream(storage.ObjectKey(opts.Org, fn_aws_key), reader)
+	// unblock the producer if the upload gave up early
+	reader.CloseWithError(err)
+
+	utils.PanicIfError("Error uploading file - ", err)
+	utils.Timing(start, fmt.Sprintf("\tProcessed file '%s' in ", fn_source) + "%f seconds")
+}
+
+// In envelope mode every file gets a data key of its own, recorded wrapped on the file for the manifest
+func newDataKey(_datakeys encrypt.DataKeys, fs *file.File) []byte {
+	if _datakeys == nil {
+		return nil
+	}
+	data_key, wrapped, err := _datakeys.Generate()
+	utils.PanicIfError("Unable to generate data key - ", err)
+	fs.DataKey = wrapped
+	return data_key
+}
+
+// With --opaque-names every file is stored under a random name of its own, kept when a metadata file is uploaded to later batches
+func newObjectKey(fs *file.File, opts options.Options) {
+	if !opts.OpaqueNames || fs.Key != "" {
+		return
+	}
+	err := fs.NewKey()
+	utils.PanicIfError("Unable to generate object key - ", err)
+}
+
+// Encrypts the manifest the way the objects are and uploads it next to them, returning the stub to upload in its place
+func uploadSealedManifest(backend storage.Backend, _pubkeys openpgp.EntityList, _signkey *openpgp.Entity, _datakeys encrypt.DataKeys, m manifest.Manifest, manifest_bytes []byte, opts options.Options) []byte {
+	sealed := manifest.Sealed{Name: m.Name + manifest.SealedSuffix}
+	var data_key []byte
+	if _datakeys != nil {
+		var err error
+		data_key, sealed.DataKey, err = _datakeys.Generate()
+		utils.PanicIfError("Unable to generate data key - ", err)
+	}
+
+	encrypted := new(bytes.Buffer)
+	w, err := newObjectWriter(_pubkeys, _signkey, data_key, encrypted, compress.Gzip, opts)
+	utils.PanicIfError("Unable to encrypt Manifest - ", err)
+	_, err = w.Write(manifest_bytes)
+	if err == nil {
+		err = w.Close()
+	}
+	utils.PanicIfError("Unable to encrypt Manifest - ", err)
+	err = backend.PutStream(storage.ObjectKey(opts.Org, m.Folder, sealed.Name), encrypted)
+	utils.PanicIfError("Error uploading encrypted Manifest", err)
+
+	stub_bytes, err := m.Stub(sealed).Marshal()
+	utils.PanicIfError("Error marshalling Manifest", err)
+	return stub_bytes
+}
+
+// Objects are sealed under their data key in envelope mode, otherwise encrypted to the receivers' public keys.
+// Only the latter are signed by _signkey, envelope objects are covered by the manifest signature alone.
+func newObjectWriter(_pubkeys openpgp.EntityList, _signkey *openpgp.Entity, data_key []byte, out io.Writer, codec string, opts options.Options) (io.WriteCloser, error) {
+	if data_key != nil {
+		return encrypt.NewEnvelopeWriter(data_key, out, codec, opts)
+	}
+	return encrypt.NewEncryptWriter(_pubkeys, _signkey, out, codec, opts)
 }
 
 // buildContext sets up the ShareContext we're going to use
@@ -284,8 +576,32 @@ func buildShareOptions(cmd *cobra.Command) options.Options {
 
     // if the index file isn't defined, don't Clean a blank string into a file path
     shareFromList := viper.GetString("share-from-list")
+    streaming := viper.GetBool("streaming")
+    memory_limit := viper.GetInt("memory-limit")
+    resume := viper.GetString("resume")
+    hash := viper.GetBool("hash")
+    binary := viper.GetBool("binary")
+    kms_key_id := viper.GetString("kms-key-id")
+    opaque_names := viper.GetBool("opaque-names")
+    kms_endpoint_url := viper.GetString("kms-endpoint-url")
+    vault_addr := viper.GetString("vault-addr")
+    vault_transit_key := viper.GetString("vault-transit-key")
+    compression := viper.GetString("compression")
+    compression_level := viper.GetInt("compression-level")
+    no_compress_extensions := viper.GetString("no-compress-extensions")
+    no_compress_types := viper.GetString("no-compress-types")
+    sign_key := viper.GetString("sender-private-key")
+    ssm_sign_key := viper.GetString("ssm-sender-private-key")
+    passphrase_env := viper.GetString("passphrase-env")
+    passphrase_fd := viper.GetString("passphrase-fd")
+    ssm_passphrase := viper.GetString("ssm-passphrase")
+    if resume != "" {
+        resume = filepath.Clean(resume)
+    }
     if shareFromList != "" {
         filepath.Clean(shareFromList)
+        // files from a list are always streamed, there is no directory to write the manifest or scratch files to
+        streaming = true
     }
 
 	awsKey := viper.GetString("awskey")
@@ -294,8 +610,20 @@ func buildShareOptions(cmd *cobra.Command) options.Options {
 	org := viper.GetString("org")
 	prefix := viper.GetString("prefix")
 
-	pubKey := filepath.Clean(viper.GetString("receiver-public-key"))
+	// several receivers may be given, each object is encrypted to all of them
+	var pubKeys []string
+	for _, pubKey := range utils.SplitList(viper.GetString("receiver-public-key")) {
+	    // Vault references and urls are not paths
+	    if !vault.IsRef(pubKey) && !encrypt.IsKeyURL(pubKey) {
+	        pubKey = filepath.Clean(pubKey)
+	    }
+	    pubKeys = append(pubKeys, pubKey)
+	}
+	pubKey := strings.Join(pubKeys, ",")
 	ssmPubKey := viper.GetString("ssm-public-key")
+	receiver_key_fingerprint := viper.GetString("receiver-key-fingerprint")
+	key_cache_ttl := viper.GetDuration("key-cache-ttl")
+	key_cache_max_stale := viper.GetDuration("key-cache-max-stale")
 	isGCS := viper.GetBool("is-gcs")
 
 	archive_directory := viper.GetString("archive-directory")
@@ -305,6 +633,15 @@ func buildShareOptions(cmd *cobra.Command) options.Options {
 	chunkSize := viper.GetInt("chunk-size")
 	batchSize := viper.GetInt("batch-size")
 	aws_role_arn := viper.GetString("aws-role-arn")
+	endpoint_url := viper.GetString("endpoint-url")
+	path_style := viper.GetBool("path-style")
+	ca_bundle := viper.GetString("ca-bundle")
+	azure_account := viper.GetString("azure-account")
+	azure_account_key := viper.GetString("azure-account-key")
+	azure_sas_token := viper.GetString("azure-sas-token")
+	azure_connection_string := viper.GetString("azure-connection-string")
+	sftp_key := viper.GetString("sftp-key")
+	sftp_known_hosts := viper.GetString("sftp-known-hosts")
 
 	deleteOnCompletion := viper.GetBool("delete-on-completion")
 
@@ -324,6 +661,9 @@ func buildShareOptions(cmd *cobra.Command) options.Options {
 		Prefix             : prefix,
 		PubKey             : pubKey,
 		SSMPubKey          : ssmPubKey,
+		ReceiverKeyFingerprint: receiver_key_fingerprint,
+		KeyCacheTtl        : key_cache_ttl,
+		KeyCacheMaxStale   : key_cache_max_stale,
 		IsGCS          	   : isGCS,
 		ScratchDirectory   : scratch_directory,
 		ArchiveDirectory   : archive_directory,
@@ -335,7 +675,35 @@ func buildShareOptions(cmd *cobra.Command) options.Options {
 		LambdaTrigger      : lambdaTrigger,
 		DeleteOnCompletion : deleteOnCompletion,
 		ShareFromList      : shareFromList,
+		Streaming          : streaming,
+		MemoryLimit        : memory_limit,
+		Resume             : resume,
+		Hash               : hash,
+		Binary             : binary,
+		KmsKeyId           : kms_key_id,
+		OpaqueNames        : opaque_names,
+		KmsEndpointUrl     : kms_endpoint_url,
+		VaultAddr          : vault_addr,
+		VaultTransitKey    : vault_transit_key,
+		Compression        : compression,
+		CompressionLevel   : compression_level,
+		NoCompressExtensions : no_compress_extensions,
+		NoCompressTypes    : no_compress_types,
+		SignKey            : sign_key,
+		SSMSignKey         : ssm_sign_key,
+		PassphraseEnv      : passphrase_env,
+		PassphraseFd       : passphrase_fd,
+		SSMPassphrase      : ssm_passphrase,
 		AwsRoleArn		   : aws_role_arn,
+		EndpointUrl        : endpoint_url,
+		PathStyle          : path_style,
+		CaBundle           : ca_bundle,
+		AzureAccount       : azure_account,
+		AzureAccountKey    : azure_account_key,
+		AzureSasToken      : azure_sas_token,
+		AzureConnectionString : azure_connection_string,
+		SftpKey            : sftp_key,
+		SftpKnownHosts     : sftp_known_hosts,
 	}
 
 	debug := viper.GetBool("debug")
@@ -343,7 +711,7 @@ func buildShareOptions(cmd *cobra.Command) options.Options {
 		log.SetLevel(log.InfoLevel)
 	}
 
-	log.Debugf("Captured options: %s", options)
+	log.Debugf("Captured options: %+v", options)
 
 	return options
 }
@@ -351,8 +719,27 @@ func buildShareOptions(cmd *cobra.Command) options.Options {
 func checkShareOptions(options options.Options) {
     log.Debug("Checking input arguments...")
 
-	if options.AwsKey == "" && options.PubKey == "" && options.SSMPubKey == "" {
-		panic("Need to supply either AWS Key for S3 level encryption or a public key for GPG encryption or both!. Insufficient key material to perform safe encryption.")
+	if options.AwsKey == "" && options.PubKey == "" && options.SSMPubKey == "" && options.KmsKeyId == "" && options.VaultTransitKey == "" {
+		panic("Need to supply either AWS Key for S3 level encryption, a public key for GPG encryption or a KMS or Vault transit key for envelope encryption. Insufficient key material to perform safe encryption.")
+	}
+
+	if options.KmsKeyId != "" && (options.PubKey != "" || options.SSMPubKey != "") {
+		panic("Do not use both '--kms-key-id' and receiver public keys, a batch is either sealed with KMS data keys or encrypted with GPG.")
+	}
+
+	if options.VaultTransitKey != "" && (options.PubKey != "" || options.SSMPubKey != "" || options.KmsKeyId != "") {
+		panic("Do not use '--vault-transit-key' with receiver public keys or '--kms-key-id', a batch is sealed with data keys from one source.")
+	}
+
+	// envelope objects carry no signature, the signed manifest holds every data key instead
+	if (options.SignKey != "" || options.SSMSignKey != "") && encrypt.Enveloped(encrypt.OutputFormat(options)) {
+		log.Warn("Objects sealed with KMS or Vault transit data keys are not signed, the sender key only signs the manifest, which covers every data key.")
+	}
+
+	for _, pubKey := range utils.SplitList(options.PubKey) {
+		if encrypt.IsKeyURL(pubKey) && options.ReceiverKeyFingerprint == "" {
+			panic("Receiver public keys fetched from a url must be pinned with '--receiver-key-fingerprint'.")
+		}
 	}
 
 	if options.Org == "" {
@@ -375,6 +762,13 @@ func checkShareOptions(options options.Options) {
         panic("Input directory cannot be root!")
     }
 
+    if !compress.Valid(options.Compression) {
+        panic("Compression must be one of '" + compress.None + "', '" + compress.Gzip + "' or '" + compress.Zstd + "'.")
+    }
+    if _, err := compress.NewWriter(options.Compression, options.CompressionLevel, io.Discard); err != nil {
+        panic("Invalid compression level - " + err.Error())
+    }
+
 	if !strings.Contains(strings.ToLower(options.Prefix), "clinical") && !strings.Contains(strings.ToLower(options.Prefix), "documents") && !strings.Contains(strings.ToLower(options.Prefix), "imaging") && !strings.Contains(strings.ToLower(options.Prefix), "molecular"){
 	    panic("Prefix command line argument must contain 'clinical' or 'documents' to abide by our lambda trigger!")
 	}
@@ -404,13 +798,35 @@ func init() {
     shareCmd.PersistentFlags().String("metadata-files", "", "If provided, these files are the first to be uploaded and the last to be archived out of the input directory. Comma-separated. I.E. --metadata-files=file1,file2,file3")
     shareCmd.PersistentFlags().Bool("delete-on-completion", true, "If provided, provided directory will be deleted upon the upload of the files.")
     shareCmd.PersistentFlags().String("share-from-list", "", "Local path and filename for encrypting files directly from a CSV index.")
+    shareCmd.PersistentFlags().Bool("binary", false, "Upload encrypted files as binary OpenPGP instead of ASCII armor, which makes every object about a quarter smaller. Decrypt detects the format on its own, but receivers need a version of s3s2 that reads binary output.")
+    shareCmd.PersistentFlags().String("compression", compress.Gzip, "Codec every file is compressed with before it is encrypted - none, gzip or zstd. The codec is recorded per file in the manifest, receivers need a version of s3s2 that reads it to decrypt zstd or none.")
+    shareCmd.PersistentFlags().Int("compression-level", 0, "Level of the compression codec, gzip takes 1 to 9 and zstd 1 to 22. 0 uses the codec's default, which is 9 for gzip and 3 for zstd.")
+    shareCmd.PersistentFlags().String("no-compress-extensions", compress.DefaultSkipExtensions, "Files with these extensions are already compressed and are shared without compressing them again. Comma-separated, empty compresses every file.")
+    shareCmd.PersistentFlags().String("no-compress-types", compress.DefaultSkipTypes, "Files whose sniffed content type starts with one of these are shared without compressing them again. Comma-separated, empty turns sniffing off.")
+    shareCmd.PersistentFlags().String("kms-key-id", "", "Seal every file with AES-256-GCM under a data key of its own from this KMS key instead of GPG. The wrapped data keys are recorded in the manifest, receivers only need kms:Decrypt on the key. An ID, ARN or alias.")
+    shareCmd.PersistentFlags().String("kms-endpoint-url", "", "Send KMS requests to this endpoint instead of the regional one, i.e. a VPC endpoint.")
+    shareCmd.PersistentFlags().String("vault-transit-key", "", "Seal every file with AES-256-GCM under a data key of its own from this Vault transit key, given as <mount>/<name>. The wrapped data keys are recorded in the manifest, receivers only need decrypt on the transit key.")
+    shareCmd.PersistentFlags().String("vault-addr", "", "The Vault server keys given as vault://<mount>/<path>#<field> and --vault-transit-key are read from. Defaults to $VAULT_ADDR, the token is read from $VAULT_TOKEN or ~/.vault-token.")
+    shareCmd.PersistentFlags().Bool("opaque-names", false, "Store every file under a random object name and upload the manifest encrypted, so bucket listings and the manifest left in the clear reveal no file names. Receivers need a version of s3s2 that reads encrypted manifests.")
+    shareCmd.PersistentFlags().Bool("hash", true, "Record the SHA-256 of every file in the manifest so decrypt can verify it. Sizes and modification times are always recorded.")
+    shareCmd.PersistentFlags().String("resume", "", "Path to the journal of an interrupted share. Continues uploading into the same batch folders, skipping files that were already uploaded. Journals are written to the scratch directory, or the archive directory if no scratch directory is provided.")
+    shareCmd.PersistentFlags().Int("memory-limit", 1024, "Ceiling in MB on the upload buffers held across all parallel workers while streaming. Uploads wait for room under the ceiling, and S3 part sizes shrink to fit it. At least 15 for S3 and 16 for GCS, 0 disables the ceiling.")
+    shareCmd.PersistentFlags().Bool("streaming", false, "If provided, each file is zipped, encrypted and uploaded in a single pass without writing .zip or .gpg files, so read-only directories can be shared without a scratch directory.")
 	shareCmd.PersistentFlags().String("aws-role-arn", "", "AWS Role ARN to assume for the session.")
 
     // ssm key options
 	shareCmd.PersistentFlags().String("awskey", "", "The agreed upon S3 key to encrypt data with at the bucket.")
-	shareCmd.PersistentFlags().String("receiver-public-key", "", "The receiver's public key.  A local file path.")
-	shareCmd.PersistentFlags().String("ssm-public-key", "", "The receiver's public key.  A local file path.")
-    shareCmd.PersistentFlags().Bool("is-gcs", false, "Boolean to determine whether to use GCS. Defaults to false.")
+	shareCmd.PersistentFlags().String("receiver-public-key", "", "The receivers' public keys, every file is encrypted to each of them.  Comma-separated local file paths, https urls or vault://<mount>/<path>#<field> secrets.")
+	shareCmd.PersistentFlags().String("receiver-key-fingerprint", "", "Required for keys fetched from a url, share refuses any key served there whose fingerprint is not listed.  Comma-separated fingerprints as printed by gpg.")
+	shareCmd.PersistentFlags().Duration("key-cache-ttl", 24*time.Hour, "How long keys fetched from a url are used before being fetched again.")
+	shareCmd.PersistentFlags().Duration("key-cache-max-stale", 7*24*time.Hour, "How old a cached key may be and still be used when its url can't be reached. 0 never uses a cached key in place of fetching it.")
+	shareCmd.PersistentFlags().String("ssm-public-key", "", "The receivers' public keys, every file is encrypted to each of them.  Comma-separated parameter names in SSM.")
+	shareCmd.PersistentFlags().String("sender-private-key", "", "The sender's private key, used to sign every encrypted file and the manifest.  A local file path.")
+	shareCmd.PersistentFlags().String("ssm-sender-private-key", "", "The sender's private key, used to sign every encrypted file and the manifest.  A parameter name in SSM.")
+	shareCmd.PersistentFlags().String("passphrase-env", "", "Name of an environment variable holding the passphrase of a protected sender private key.")
+	shareCmd.PersistentFlags().String("passphrase-fd", "", "File descriptor to read the passphrase of a protected sender private key from, only the first line is read.")
+	shareCmd.PersistentFlags().String("ssm-passphrase", "", "The passphrase of a protected sender private key.  A parameter name in SSM.")
+    shareCmd.PersistentFlags().Bool("is-gcs", false, "Boolean to determine whether to use GCS. Defaults to false. Equivalent to passing a gs:// url as the bucket.")
 
 	viper.BindPFlag("directory", shareCmd.PersistentFlags().Lookup("directory"))
 	viper.BindPFlag("org", shareCmd.PersistentFlags().Lookup("org"))
@@ -424,11 +840,30 @@ func init() {
 	viper.BindPFlag("metadata-files", shareCmd.PersistentFlags().Lookup("metadata-files"))
 	viper.BindPFlag("awskey", shareCmd.PersistentFlags().Lookup("awskey"))
 	viper.BindPFlag("receiver-public-key", shareCmd.PersistentFlags().Lookup("receiver-public-key"))
+	viper.BindPFlag("receiver-key-fingerprint", shareCmd.PersistentFlags().Lookup("receiver-key-fingerprint"))
+	viper.BindPFlag("key-cache-ttl", shareCmd.PersistentFlags().Lookup("key-cache-ttl"))
+	viper.BindPFlag("key-cache-max-stale", shareCmd.PersistentFlags().Lookup("key-cache-max-stale"))
 	viper.BindPFlag("ssm-public-key", shareCmd.PersistentFlags().Lookup("ssm-public-key"))
+	viper.BindPFlag("sender-private-key", shareCmd.PersistentFlags().Lookup("sender-private-key"))
+	viper.BindPFlag("ssm-sender-private-key", shareCmd.PersistentFlags().Lookup("ssm-sender-private-key"))
+	viper.BindPFlag("passphrase-env", shareCmd.PersistentFlags().Lookup("passphrase-env"))
+	viper.BindPFlag("passphrase-fd", shareCmd.PersistentFlags().Lookup("passphrase-fd"))
+	viper.BindPFlag("ssm-passphrase", shareCmd.PersistentFlags().Lookup("ssm-passphrase"))
 	viper.BindPFlag("is-gcs", shareCmd.PersistentFlags().Lookup("is-gcs"))
 	viper.BindPFlag("aws-profile", shareCmd.PersistentFlags().Lookup("aws-profile"))
 	viper.BindPFlag("delete-on-completion", shareCmd.PersistentFlags().Lookup("delete-on-completion"))
     viper.BindPFlag("share-from-list", shareCmd.PersistentFlags().Lookup("share-from-list"))
+    viper.BindPFlag("streaming", shareCmd.PersistentFlags().Lookup("streaming"))
+    viper.BindPFlag("memory-limit", shareCmd.PersistentFlags().Lookup("memory-limit"))
+    viper.BindPFlag("resume", shareCmd.PersistentFlags().Lookup("resume"))
+    viper.BindPFlag("hash", shareCmd.PersistentFlags().Lookup("hash"))
+    viper.BindPFlag("binary", shareCmd.PersistentFlags().Lookup("binary"))
+    viper.BindPFlag("kms-key-id", shareCmd.PersistentFlags().Lookup("kms-key-id"))
+    viper.BindPFlag("opaque-names", shareCmd.PersistentFlags().Lookup("opaque-names"))
+    viper.BindPFlag("compression", shareCmd.PersistentFlags().Lookup("compression"))
+    viper.BindPFlag("compression-level", shareCmd.PersistentFlags().Lookup("compression-level"))
+    viper.BindPFlag("no-compress-extensions", shareCmd.PersistentFlags().Lookup("no-compress-extensions"))
+    viper.BindPFlag("no-compress-types", shareCmd.PersistentFlags().Lookup("no-compress-types"))
 	viper.BindPFlag("aws-role-arn", shareCmd.PersistentFlags().Lookup("aws-role-arn"))
 
 	//log.SetFormatter(&log.JSONFormatter{})
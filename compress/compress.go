@@ -0,0 +1,141 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	options "github.com/tempuslabs/s3s2/options"
+	utils "github.com/tempuslabs/s3s2/utils"
+)
+
+// Codecs share can compress files with before encrypting them, recorded per file in the manifest
+const (
+	None = "none"
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// Files with these extensions are already compressed and are left as they are by default
+const DefaultSkipExtensions = ".gz,.tgz,.zip,.zst,.bz2,.xz,.7z,.rar,.jpg,.jpeg,.png,.gif,.webp,.mp3,.mp4,.mov,.avi,.pdf,.docx,.xlsx,.pptx"
+
+// Sniffed content types that are already compressed, a trailing slash matches every subtype.
+// DICOM imaging mostly carries JPEG or JPEG 2000 compressed pixel data, see ContentType
+const DefaultSkipTypes = "image/,video/,audio/,application/zip,application/x-gzip,application/pdf,application/dicom"
+
+// Valid reports whether codec is one decrypt knows how to undo
+func Valid(codec string) bool {
+	return codec == None || codec == Gzip || codec == Zstd
+}
+
+// Codec returns the codec a file was recorded with, manifests written before codecs could be chosen always used gzip
+func Codec(recorded string) string {
+	if recorded == "" {
+		return Gzip
+	}
+	return recorded
+}
+
+// NewWriter compresses everything written to it into out with codec, a level of 0 picks the codec's default.
+// Close must be called to flush the final frame, it does not close out.
+func NewWriter(codec string, level int, out io.Writer) (io.WriteCloser, error) {
+	switch codec {
+	case None:
+		return nopWriteCloser{out}, nil
+	case Gzip:
+		// gzip has always been written at its best compression
+		if level == 0 {
+			level = gzip.BestCompression
+		}
+		return gzip.NewWriterLevel(out, level)
+	case Zstd:
+		encoder_level := zstd.SpeedDefault
+		if level != 0 {
+			encoder_level = zstd.EncoderLevelFromZstd(level)
+		}
+		return zstd.NewWriter(out, zstd.WithEncoderLevel(encoder_level))
+	}
+	return nil, fmt.Errorf("unsupported compression codec '%s'", codec)
+}
+
+// NewReader decompresses what is read from in with the codec recorded for the file
+func NewReader(codec string, in io.Reader) (io.ReadCloser, error) {
+	switch Codec(codec) {
+	case None:
+		return io.NopCloser(in), nil
+	case Gzip:
+		return gzip.NewReader(in)
+	case Zstd:
+		decoder, err := zstd.NewReader(in)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unsupported compression codec '%s'", codec)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Choose picks the codec to share the file at path with, files that are already compressed
+// by their extension or sniffed content type are not compressed a second time
+func Choose(path string, opts options.Options) string {
+	codec := opts.Compression
+	if codec == "" {
+		codec = Gzip
+	}
+	if codec == None {
+		return codec
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, skip := range utils.SplitList(opts.NoCompressExtensions) {
+		if ext != "" && strings.EqualFold(ext, "."+strings.TrimPrefix(skip, ".")) {
+			return None
+		}
+	}
+
+	skip_types := utils.SplitList(opts.NoCompressTypes)
+	if len(skip_types) == 0 {
+		return codec
+	}
+	content_type := ContentType(path)
+	for _, skip := range skip_types {
+		if strings.HasPrefix(content_type, strings.ToLower(skip)) {
+			return None
+		}
+	}
+	return codec
+}
+
+// ContentType sniffs the content type of the file at path from its first bytes
+func ContentType(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+
+	// DICOM files carry their magic after a 128 byte preamble, which http does not know about
+	if len(head) >= 132 && bytes.Equal(head[128:132], []byte("DICM")) {
+		return "application/dicom"
+	}
+	return http.DetectContentType(head)
+}
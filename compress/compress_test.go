@@ -0,0 +1,38 @@
+package compress
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	options "github.com/tempuslabs/s3s2/options"
+)
+
+// with the default skip lists, files that are already compressed are shared as they are
+func TestChooseWithDefaults(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"a.txt":     []byte(strings.Repeat("top level file ", 100)),
+		"image.png": []byte("\x89PNG\r\n\x1a\n" + strings.Repeat("not really an image", 100)),
+		"scan":      []byte("\x89PNG\r\n\x1a\n" + strings.Repeat("not really an image", 100)),
+		"study":     append(make([]byte, 128), []byte("DICM"+strings.Repeat("not really a study", 100))...),
+		"short":     []byte("DICM"),
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), content, 0600))
+	}
+
+	opts := options.Options{Compression: Zstd, NoCompressExtensions: DefaultSkipExtensions, NoCompressTypes: DefaultSkipTypes}
+	assert.Equal(t, "application/dicom", ContentType(filepath.Join(dir, "study")))
+	for name, codec := range map[string]string{"a.txt": Zstd, "image.png": None, "scan": None, "study": None, "short": Zstd} {
+		assert.Equal(t, codec, Choose(filepath.Join(dir, name), opts), name)
+	}
+
+	// emptying the type list turns sniffing off
+	opts.NoCompressTypes = ""
+	assert.Equal(t, Zstd, Choose(filepath.Join(dir, "study"), opts))
+}
@@ -1,109 +1,31 @@
 package encrypt
 
 import (
+	"bufio"
 	"bytes"
-	"compress/gzip"
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
-	session "github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/ssm"
 	log "github.com/sirupsen/logrus"
-	aws_helpers "github.com/tempuslabs/s3s2/aws_helpers"
+	compress "github.com/tempuslabs/s3s2/compress"
 	options "github.com/tempuslabs/s3s2/options"
 	utils "github.com/tempuslabs/s3s2/utils"
 
 	// For the signature algorithm.
 	_ "golang.org/x/crypto/ripemd160"
 
-	"golang.org/x/crypto/openpgp"
-	"golang.org/x/crypto/openpgp/armor"
-	"golang.org/x/crypto/openpgp/packet"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
 )
 
-// Logic to fetch the public encryption key
-// depending on arguments provided, will get from SSM or provided file
-func GetPubKey(sess *session.Session, opts options.Options) *packet.PublicKey {
-    var in io.Reader
-    var err error
-
-    // if provided SSM Pub Key, then fetch from SSM
-    if opts.SSMPubKey != "" {
-        ssm_service := ssm.New(sess)
-		in = strings.NewReader(aws_helpers.GetParameterValue(ssm_service, opts.SSMPubKey, opts))
-
-    // if provided original filepath value, then use instead
-    } else if opts.PubKey != "" {
-		in, err = os.Open(opts.PubKey)
-		utils.PanicIfError("Unable to open public key file - ", err)
-    } else {
-        panic("You must provide a public key argument!")
-    }
-
-	pub_key_block, err := armor.Decode(in)
-	utils.PanicIfError("Unable to decode public key block - ", err)
-
-	if pub_key_block.Type != openpgp.PublicKeyType {
-		log.Error("Invalid public key file")
-	}
-
-	reader := packet.NewReader(pub_key_block.Body)
-	pkt, err := reader.Next()
-	utils.PanicIfError("Error creating reader from public key block - ", err)
-
-	key, ok := pkt.(*packet.PublicKey)
-	if !ok {
-		log.Error("Invalid public key")
-	}
-
-	return key
-}
-
-
-func GetPrivKey(sess *session.Session, opts options.Options) *packet.PrivateKey {
-    var in io.Reader
-    var err error
-
-    // if provided SSM Priv Key, then fetch from SSM
-    if opts.SSMPrivKey != "" {
-        ssm_service := ssm.New(sess)
-		in = strings.NewReader(aws_helpers.GetParameterValue(ssm_service, opts.SSMPrivKey, opts))
-
-    // if provided original filepath value, then use instead
-    } else if opts.PrivKey != "" {
-		in, err = os.Open(opts.PrivKey)
-		utils.PanicIfError("Unable to open private key block - ", err)
-
-    } else {
-        panic("You must provide a private key argument!")
-    }
-
-	priv_key_block, err := armor.Decode(in)
-    utils.PanicIfError("Unable to decode private key block - ", err)
-
-	if priv_key_block.Type != openpgp.PrivateKeyType {
-		log.Error("Invalid private key file")
-	}
-
-	reader := packet.NewReader(priv_key_block.Body)
-
-	pkt, err := reader.Next()
-	utils.PanicIfError("Unable to read private key packet - ", err)
-
-	key, ok := pkt.(*packet.PrivateKey)
-	if !ok {
-		log.Error("Invalid private key")
-	}
-
-	return key
-}
-
 func getEncryptionConfig() packet.Config {
 	config := packet.Config{
 		DefaultHash:            crypto.SHA256,
@@ -135,18 +57,20 @@ func createEntityFromKeys(pubKey *packet.PublicKey, privKey *packet.PrivateKey)
 		SelfSignature: &packet.Signature{
 			CreationTime: currentTime,
 			SigType:      packet.SigTypePositiveCert,
-			PubKeyAlgo:   packet.PubKeyAlgoRSA,
+			PubKeyAlgo:   pubKey.PubKeyAlgo,
 			Hash:         config.Hash(),
 			IsPrimaryId:  &isPrimaryID,
 			FlagsValid:   true,
 			FlagSign:     true,
 			FlagCertify:  true,
 			IssuerKeyId:  &e.PrimaryKey.KeyId,
+			// without preferences openpgp falls back to CAST5 and RIPEMD-160, which gpg keyrings do not list,
+			// so a share to both kinds of key would find no common algorithm
+			PreferredSymmetric: []uint8{uint8(packet.CipherAES256), uint8(packet.CipherAES128)},
+			PreferredHash:      []uint8{8, 10}, // SHA-256, SHA-512
 		},
 	}
 
-	keyLifetimeSecs := uint32(86400 * 365)
-
 	e.Subkeys = make([]openpgp.Subkey, 1)
 	e.Subkeys[0] = openpgp.Subkey{
 		PublicKey:  pubKey,
@@ -154,40 +78,131 @@ func createEntityFromKeys(pubKey *packet.PublicKey, privKey *packet.PrivateKey)
 		Sig: &packet.Signature{
 			CreationTime:              currentTime,
 			SigType:                   packet.SigTypeSubkeyBinding,
-			PubKeyAlgo:                packet.PubKeyAlgoRSA,
+			PubKeyAlgo:                pubKey.PubKeyAlgo,
 			Hash:                      config.Hash(),
 			PreferredHash:             []uint8{8}, // SHA-256
 			FlagsValid:                true,
 			FlagEncryptStorage:        true,
 			FlagEncryptCommunications: true,
 			IssuerKeyId:               &e.PrimaryKey.KeyId,
-			KeyLifetimeSecs:           &keyLifetimeSecs,
 		},
 	}
 	return &e
 }
 
-func EncryptFile(pubKey *packet.PublicKey, InputFn string, OutputFn string, Opts options.Options) string {
-    log.Debugf("Encrypting file '%s' to '%s'...", InputFn, OutputFn)
+// How encrypted objects are encoded, recorded in the manifest so decrypt knows which to expect
+const (
+	FormatArmored = "armored"
+	FormatBinary  = "binary"
+)
 
-	to := createEntityFromKeys(pubKey, nil) // We shouldn't have the receiver's private key!
+// OutputFormat is the encoding share writes with the given options
+func OutputFormat(opts options.Options) string {
+	if opts.KmsKeyId != "" {
+		return FormatKMS
+	}
+	if opts.VaultTransitKey != "" {
+		return FormatTransit
+	}
+	if opts.Binary {
+		return FormatBinary
+	}
+	return FormatArmored
+}
+
+// Algorithms names the cipher and hash objects of the format are protected with, envelope formats have no hash
+func Algorithms(format string) (string, string) {
+	if Enveloped(format) {
+		return "AES-256-GCM", ""
+	}
+	config := getEncryptionConfig()
+	return fmt.Sprintf("AES-%d", config.DefaultCipher.KeySize()*8), config.DefaultHash.String()
+}
+
+// binary output has no armor to close
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// encryptWriter closes each layer of the encryption pipeline, innermost first
+type encryptWriter struct {
+	compressed io.WriteCloser
+	plain      io.WriteCloser
+	armored    io.WriteCloser
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	return e.compressed.Write(p)
+}
+
+func (e *encryptWriter) Close() error {
+	if err := e.compressed.Close(); err != nil {
+		return err
+	}
+	if err := e.plain.Close(); err != nil {
+		return err
+	}
+	return e.armored.Close()
+}
+
+// NewEncryptWriter returns a writer that compresses everything written to it with codec and encrypts it into out.
+// The session key is encrypted to every one of the recipients, so any of them can decrypt the message on their own.
+// When signer is provided the message is also signed by the sender.
+// The message is armored unless Opts.Binary is set, which saves the third armor adds to every object.
+// Close must be called to flush the final blocks, it does not close out.
+func NewEncryptWriter(to openpgp.EntityList, signer *openpgp.Entity, out io.Writer, codec string, Opts options.Options) (io.WriteCloser, error) {
+	if len(to) == 0 {
+		return nil, errors.New("no recipient public keys")
+	}
+
+	var w io.WriteCloser = nopWriteCloser{out}
+	if !Opts.Binary {
+		var err error
+		if w, err = armor.Encode(out, "Message", make(map[string]string)); err != nil {
+			return nil, err
+		}
+	}
+
+	config := getEncryptionConfig()
+	plain, err := openpgp.Encrypt(w, to, signer, &openpgp.FileHints{IsBinary: true}, &config)
+	if err != nil {
+		return nil, err
+	}
+
+	compressed, err := compress.NewWriter(codec, Opts.CompressionLevel, plain)
+	if err != nil {
+		return nil, err
+	}
+
+	return &encryptWriter{compressed: compressed, plain: plain, armored: w}, nil
+}
+
+func EncryptFile(to openpgp.EntityList, signer *openpgp.Entity, InputFn string, OutputFn string, codec string, Opts options.Options) string {
+	return encryptFile(InputFn, OutputFn, func(out io.Writer) (io.WriteCloser, error) {
+		return NewEncryptWriter(to, signer, out, codec, Opts)
+	})
+}
+
+// EnvelopeEncryptFile seals the file under data_key instead of encrypting it to public keys, see NewEnvelopeWriter
+func EnvelopeEncryptFile(data_key []byte, InputFn string, OutputFn string, codec string, Opts options.Options) string {
+	return encryptFile(InputFn, OutputFn, func(out io.Writer) (io.WriteCloser, error) {
+		return NewEnvelopeWriter(data_key, out, codec, Opts)
+	})
+}
+
+func encryptFile(InputFn string, OutputFn string, newWriter func(io.Writer) (io.WriteCloser, error)) string {
+    log.Debugf("Encrypting file '%s' to '%s'...", InputFn, OutputFn)
 
 	ofile, err := os.Create(OutputFn)
     utils.PanicIfError("Unable to create encrypted file - ", err)
 	defer ofile.Close()
 
-	w, err := armor.Encode(ofile, "Message", make(map[string]string))
-	utils.PanicIfError("Unable to encode encrypted file location - ", err)
-	defer w.Close()
-
-    config := getEncryptionConfig()
-	// Here the signer should be the sender
-	plain, err := openpgp.Encrypt(w, []*openpgp.Entity{to}, nil, &openpgp.FileHints{IsBinary: true}, &config)
+	compressed, err := newWriter(ofile)
 	utils.PanicIfError("Unable to perform encryption - ", err)
-	defer plain.Close()
-
-	compressed, err := gzip.NewWriterLevel(plain, gzip.BestCompression)
-   	utils.PanicIfError("Unable to perform compression - ", err)
 
 	infile, err := os.Open(InputFn)
 	utils.PanicIfError("Unable to open encrypted file location - ", err)
@@ -196,73 +211,169 @@ func EncryptFile(pubKey *packet.PublicKey, InputFn string, OutputFn string, Opts
 	_, err = io.Copy(compressed, infile)
 	utils.PanicIfError("Error writing encrypted file - ", err)
 	log.Debugf("Encrypted file: '%s'", infile.Name())
-	compressed.Close()
+
+	err = compressed.Close()
+	utils.PanicIfError("Error writing encrypted file - ", err)
 
 	return OutputFn
 }
 
-func EncryptBuffer(pubKey *packet.PublicKey, InputBf *bytes.Buffer, Opts options.Options) *bytes.Buffer {
-
-	to := createEntityFromKeys(pubKey, nil) // We shouldn't have the receiver's private key!
+func EncryptBuffer(to openpgp.EntityList, signer *openpgp.Entity, InputBf *bytes.Buffer, codec string, Opts options.Options) *bytes.Buffer {
 
 	obuffer := new(bytes.Buffer)
-	writer := io.Writer(obuffer)
-
-	w, err := armor.Encode(writer, "Message", make(map[string]string))
-	utils.PanicIfError("Unable to encode encrypted file location - ", err)
-	defer w.Close()
 
-	config := getEncryptionConfig()
-	// Here the signer should be the sender
-	plain, err := openpgp.Encrypt(w, []*openpgp.Entity{to}, nil, &openpgp.FileHints{IsBinary: true}, &config)
+	compressed, err := NewEncryptWriter(to, signer, obuffer, codec, Opts)
 	utils.PanicIfError("Unable to perform encryption - ", err)
-	defer plain.Close()
-
-	compressed, err := gzip.NewWriterLevel(plain, gzip.BestCompression)
-	utils.PanicIfError("Unable to perform compression - ", err)
-	defer compressed.Close()
 
 	_, err = io.Copy(compressed, InputBf)
 	utils.PanicIfError("Error writing encrypted file - ", err)
 
+	err = compressed.Close()
+	utils.PanicIfError("Error writing encrypted file - ", err)
+
 	return obuffer
 }
 
-func DecryptFile(_pubkey *packet.PublicKey, _privkey *packet.PrivateKey, InputFn string, OutputFn string, opts options.Options) {
-    log.Infof("Decrypting file '%s' to '%s'", InputFn, OutputFn)
+// decryptReader releases the decompressor once the caller is done with the plaintext
+type decryptReader struct {
+	io.Reader
+	compressed io.ReadCloser
+}
 
-	in, err := os.Open(InputFn)
-	if err != nil {
-	    log.Errorf("Unable to open decrypted file location - '%s'", InputFn)
+func (d *decryptReader) Close() error {
+	return d.compressed.Close()
+}
+
+// signatureReader checks who signed the message once it has been read to the end, which is when openpgp verifies the signature
+type signatureReader struct {
+	md      *openpgp.MessageDetails
+	senders openpgp.EntityList
+	strict  bool
+}
+
+func (s *signatureReader) Read(p []byte) (int, error) {
+	n, err := s.md.UnverifiedBody.Read(p)
+	if err == io.EOF {
+		if verr := verifySender(s.md, s.senders, s.strict); verr != nil {
+			return n, verr
+		}
 	}
-	defer in.Close()
+	return n, err
+}
+
+func isTrustedSender(senders openpgp.EntityList, key_id uint64) bool {
+	return len(senders.KeysById(key_id)) > 0
+}
 
-	block, err := armor.Decode(in)
-	if block.Type != "Message" {
-		log.Errorf("Invalid message type")
+// verifySender only fails in strict mode, otherwise problems are logged and decrypt carries on
+func verifySender(md *openpgp.MessageDetails, senders openpgp.EntityList, strict bool) error {
+	var err error
+	switch {
+	case !md.IsSigned:
+		err = errors.New("message is not signed")
+	case md.SignedBy == nil || !isTrustedSender(senders, md.SignedByKeyId):
+		err = fmt.Errorf("message is signed by untrusted key %X", md.SignedByKeyId)
+	case md.SignatureError != nil:
+		err = fmt.Errorf("invalid signature from sender key %X - %s", md.SignedByKeyId, md.SignatureError)
+	default:
+		log.Debugf("Verified signature from sender key %X", md.SignedByKeyId)
+		return nil
 	}
 
-	entity := createEntityFromKeys(_pubkey, _privkey)
+	if strict {
+		return err
+	}
+	if len(senders) > 0 {
+		log.Warnf("Unable to verify sender - %s", err)
+	}
+	return nil
+}
+
+// Armored messages start with their BEGIN line, binary ones with a packet tag, which always has its high bit set
+func detectFormat(in *bufio.Reader) string {
+	if b, err := in.Peek(1); err == nil && b[0]&0x80 != 0 {
+		return FormatBinary
+	}
+	return FormatArmored
+}
+
+// NewDecryptReader returns the decrypted and decompressed contents of a message read from in, using whichever key of keyring it was encrypted to.
+// format is the encoding recorded in the manifest, when empty it is detected from the message itself.
+// codec is the compression recorded for the file, empty for files shared before it was recorded, which were always gzip'd.
+// The message's integrity check and sender signature are only verified once the returned reader has been read to the end,
+// a signature from a key outside senders is refused when opts.Strict is set.
+func NewDecryptReader(keyring openpgp.EntityList, senders openpgp.EntityList, in io.Reader, format string, codec string, opts options.Options) (io.ReadCloser, error) {
+	if format == "" {
+		buffered := bufio.NewReader(in)
+		format = detectFormat(buffered)
+		in = buffered
+	}
+
+	body := in
+	if format != FormatBinary {
+		block, err := armor.Decode(in)
+		if err != nil {
+			return nil, err
+		}
+		if block.Type != "Message" {
+			log.Errorf("Invalid message type")
+		}
+		body = block.Body
+	}
 
 	var entityList openpgp.EntityList
 
-	entityList = append(entityList, entity)
+	entityList = append(entityList, keyring...)
+	entityList = append(entityList, senders...)
 
 	config := getEncryptionConfig()
-	md, err := openpgp.ReadMessage(block.Body, entityList, nil, &config)
+	md, err := openpgp.ReadMessage(body, entityList, nil, &config)
 	if err != nil {
-		log.Errorf("Unable to read encryption - '%s'", InputFn)
-    }
+		return nil, err
+	}
 
-	compressed, err := gzip.NewReader(md.UnverifiedBody)
+	compressed, err := compress.NewReader(codec, &signatureReader{md: md, senders: senders, strict: opts.Strict})
 	if err != nil {
-	    log.Errorf("Unable to open compressed encryption information location - '%s'", InputFn)
-    }
+		return nil, err
+	}
+
+	return &decryptReader{Reader: compressed, compressed: compressed}, nil
+}
+
+func DecryptFile(keyring openpgp.EntityList, senders openpgp.EntityList, InputFn string, OutputFn string, format string, codec string, opts options.Options) error {
+	return decryptFile(InputFn, OutputFn, func(in io.Reader) (io.ReadCloser, error) {
+		return NewDecryptReader(keyring, senders, in, format, codec, opts)
+	})
+}
+
+// EnvelopeDecryptFile opens a file sealed under data_key, see NewEnvelopeReader
+func EnvelopeDecryptFile(data_key []byte, InputFn string, OutputFn string, codec string) error {
+	return decryptFile(InputFn, OutputFn, func(in io.Reader) (io.ReadCloser, error) {
+		return NewEnvelopeReader(data_key, in, codec)
+	})
+}
+
+func decryptFile(InputFn string, OutputFn string, newReader func(io.Reader) (io.ReadCloser, error)) error {
+    log.Infof("Decrypting file '%s' to '%s'", InputFn, OutputFn)
+
+	in, err := os.Open(InputFn)
+	if err != nil {
+	    log.Errorf("Unable to open decrypted file location - '%s'", InputFn)
+	    return err
+	}
+	defer in.Close()
+
+	compressed, err := newReader(in)
+	if err != nil {
+		log.Errorf("Unable to read encryption - '%s'", InputFn)
+		return err
+	}
 	defer compressed.Close()
 
 	dfile, err := os.Create(OutputFn)
 	if err != nil {
 	    log.Errorf("Unable to create encrypted file location - '%s'", OutputFn)
+	    return err
     }
 	defer dfile.Close()
 
@@ -270,37 +381,97 @@ func DecryptFile(_pubkey *packet.PublicKey, _privkey *packet.PrivateKey, InputFn
 	if err != nil {
 	    log.Errorf("Unable to open encrypted file location - '%s'", OutputFn)
 	}
+	return err
 }
 
-func encodePrivateKey(out io.Writer, key *rsa.PrivateKey) {
-	w, err := armor.Encode(out, openpgp.PrivateKeyType, make(map[string]string))
-	utils.PanicIfError("Error executing armor.Encode for private key", err)
-
-	pgpKey := packet.NewRSAPrivateKey(time.Now(), key)
-	err = pgpKey.Serialize(w)
-    utils.PanicIfError("Error serializing GPG key for private key", err)
+// SignDetached returns an armored signature of data by the sender
+func SignDetached(signer *openpgp.Entity, data []byte) ([]byte, error) {
+	config := getEncryptionConfig()
+	signature := new(bytes.Buffer)
+	err := openpgp.ArmoredDetachSign(signature, signer, bytes.NewReader(data), &config)
+	return signature.Bytes(), err
+}
 
-	err = w.Close()
-	utils.PanicIfError("Error closing public key file", err)
+// VerifyDetached checks an armored signature of data was made by one of the trusted senders
+func VerifyDetached(senders openpgp.EntityList, data []byte, signature []byte) error {
+	config := getEncryptionConfig()
+	signer, err := openpgp.CheckArmoredDetachedSignature(senders, bytes.NewReader(data), bytes.NewReader(signature), &config)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Verified signature from sender key %X", signer.PrimaryKey.KeyId)
+	return nil
 }
 
-func encodePublicKey(out io.Writer, key *rsa.PrivateKey) {
-	w, err := armor.Encode(out, openpgp.PublicKeyType, make(map[string]string))
-	utils.PanicIfError("Error executing armor.Encode for public key", err)
+// Key algorithms genkey can generate
+const (
+	// a bare RSA key, as genkey has always written
+	AlgoRSA = "rsa"
+	// Ed25519 signing key with a Curve25519 encryption subkey, in the format gpg 2.2 and later read
+	AlgoCurve25519 = "curve25519"
+	// Ed25519 signing key with an X25519 encryption subkey, using the algorithms of RFC 9580
+	AlgoEd25519 = "ed25519"
+)
 
-	pgpKey := packet.NewRSAPublicKey(time.Now(), &key.PublicKey)
-	err = pgpKey.Serialize(w)
-    utils.PanicIfError("Error serializing GPG key for public key", err)
+// writeArmored wraps the output of serialize in an armored block of the given type
+func writeArmored(out io.Writer, block_type string, serialize func(io.Writer) error) {
+	w, err := armor.Encode(out, block_type, make(map[string]string))
+	utils.PanicIfError("Error executing armor.Encode for "+block_type, err)
+
+	err = serialize(w)
+	utils.PanicIfError("Error serializing GPG key for "+block_type, err)
 
 	err = w.Close()
-	utils.PanicIfError("Error closing public key file", err)
+	utils.PanicIfError("Error closing "+block_type, err)
 }
 
-// GenerateKeys PGP Keys
-func GenerateKeys(directory string, keyname string, bits int) {
+// Bare RSA key packets are written on their own, public and private share one creation time so their key IDs match
+func writeRSAKeys(priv io.Writer, pub io.Writer, bits int, passphrase []byte) {
 	key, err := rsa.GenerateKey(rand.Reader, bits)
 	utils.PanicIfError("Error generating encryption key", err)
 
+	created := time.Now()
+	writeArmored(priv, openpgp.PrivateKeyType, func(w io.Writer) error {
+		pgpKey := packet.NewRSAPrivateKey(created, key)
+		if passphrase != nil {
+			if err := pgpKey.Encrypt(passphrase); err != nil {
+				return err
+			}
+		}
+		return pgpKey.Serialize(w)
+	})
+	writeArmored(pub, openpgp.PublicKeyType, packet.NewRSAPublicKey(created, &key.PublicKey).Serialize)
+}
+
+// ECC keys need a signing primary key and an encryption subkey, so they are written as a full keyring named after the key
+func writeECCKeys(priv io.Writer, pub io.Writer, keyname string, algo string, passphrase []byte) {
+	config := getEncryptionConfig()
+	switch algo {
+	case AlgoCurve25519:
+		config.Algorithm = packet.PubKeyAlgoEdDSA
+		config.Curve = packet.Curve25519
+	case AlgoEd25519:
+		config.Algorithm = packet.PubKeyAlgoEd25519
+	}
+	e, err := openpgp.NewEntity(keyname, "", "", &config)
+	utils.PanicIfError("Error generating encryption key", err)
+
+	writeArmored(pub, openpgp.PublicKeyType, e.Serialize)
+	if passphrase != nil {
+		err = e.EncryptPrivateKeys(passphrase, nil)
+		utils.PanicIfError("Error protecting private key with passphrase", err)
+	}
+	writeArmored(priv, openpgp.PrivateKeyType, func(w io.Writer) error {
+		return e.SerializePrivateWithoutSigning(w, nil)
+	})
+}
+
+// GenerateKeys PGP Keys of the given algorithm, bits only applies to RSA. The private key is protected when a passphrase is given.
+func GenerateKeys(directory string, keyname string, algo string, bits int, passphrase []byte) {
+	if algo != AlgoRSA && algo != AlgoCurve25519 && algo != AlgoEd25519 {
+		panic("Unsupported key algorithm '" + algo + "', must be one of " + AlgoRSA + ", " + AlgoCurve25519 + " or " + AlgoEd25519)
+	}
+
 	priv, err := os.Create(filepath.Join(directory, keyname+".privkey"))
 	utils.PanicIfError("Error creating private encryption key", err)
 	defer priv.Close()
@@ -309,6 +480,9 @@ func GenerateKeys(directory string, keyname string, bits int) {
 	utils.PanicIfError("Error creating public encryption key", err)
 	defer pub.Close()
 
-	encodePrivateKey(priv, key)
-	encodePublicKey(pub, key)
+	if algo == AlgoRSA {
+		writeRSAKeys(priv, pub, bits, passphrase)
+	} else {
+		writeECCKeys(priv, pub, keyname, algo, passphrase)
+	}
 }
@@ -0,0 +1,73 @@
+package encrypt
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	compress "github.com/tempuslabs/s3s2/compress"
+	options "github.com/tempuslabs/s3s2/options"
+)
+
+func testKeys(t *testing.T, name string) (openpgp.EntityList, openpgp.EntityList) {
+	dir := t.TempDir()
+	GenerateKeys(dir, name, AlgoCurve25519, 0, nil)
+	opts := options.Options{PubKey: filepath.Join(dir, name+".pubkey"), PrivKey: filepath.Join(dir, name+".privkey")}
+	return GetPubKeys(nil, opts), GetPrivKey(nil, opts)
+}
+
+// what is written to the stream decrypts back for every format and codec, the writer never closes out
+func TestEncryptWriterRoundTrip(t *testing.T) {
+	pub, priv := testKeys(t, "receiver")
+	content := bytes.Repeat([]byte("top level file "), 10000)
+
+	for _, binary := range []bool{false, true} {
+		for _, codec := range []string{compress.None, compress.Gzip, compress.Zstd} {
+			opts := options.Options{Binary: binary}
+			var object bytes.Buffer
+			w, err := NewEncryptWriter(pub, nil, &object, codec, opts)
+			require.NoError(t, err)
+			_, err = w.Write(content)
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+
+			format := OutputFormat(opts)
+			r, err := NewDecryptReader(priv, nil, &object, format, codec, opts)
+			require.NoError(t, err, "%s %s", format, codec)
+			decrypted, err := io.ReadAll(r)
+			require.NoError(t, err, "%s %s", format, codec)
+			require.NoError(t, r.Close())
+			assert.Equal(t, content, decrypted, "%s %s", format, codec)
+		}
+	}
+}
+
+// the format is detected from the message when the manifest doesn't record one
+func TestDecryptReaderDetectsFormat(t *testing.T) {
+	pub, priv := testKeys(t, "receiver")
+
+	for _, binary := range []bool{false, true} {
+		var object bytes.Buffer
+		w, err := NewEncryptWriter(pub, nil, &object, compress.Gzip, options.Options{Binary: binary})
+		require.NoError(t, err)
+		_, err = w.Write([]byte("top level file"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		r, err := NewDecryptReader(priv, nil, &object, "", compress.Gzip, options.Options{})
+		require.NoError(t, err)
+		decrypted, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "top level file", string(decrypted))
+	}
+}
+
+func TestEncryptWriterNeedsRecipients(t *testing.T) {
+	_, err := NewEncryptWriter(nil, nil, io.Discard, compress.Gzip, options.Options{})
+	assert.Error(t, err)
+}
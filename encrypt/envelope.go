@@ -0,0 +1,278 @@
+package encrypt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	session "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+
+	compress "github.com/tempuslabs/s3s2/compress"
+	options "github.com/tempuslabs/s3s2/options"
+	utils "github.com/tempuslabs/s3s2/utils"
+	vault "github.com/tempuslabs/s3s2/vault"
+)
+
+// Envelope formats seal each object with AES-256-GCM under a data key of its own, which is wrapped for the manifest.
+// FormatKMS data keys are wrapped by an AWS KMS key, FormatTransit ones by a Vault transit key.
+const (
+	FormatKMS     = "kms"
+	FormatTransit = "vault-transit"
+)
+
+// Enveloped reports whether objects of the format are sealed under data keys rather than encrypted with GPG
+func Enveloped(format string) bool {
+	return format == FormatKMS || format == FormatTransit
+}
+
+// DataKeys generates and unwraps the per-file data keys of envelope encryption
+type DataKeys interface {
+	// Generate returns a new data key for a single file, along with its wrapped copy to record in the manifest
+	Generate() ([]byte, string, error)
+	// Unwrap recovers a data key recorded in the manifest
+	Unwrap(wrapped string) ([]byte, error)
+}
+
+// NewDataKeys returns the data keys of an envelope format, nil for batches encrypted with GPG
+func NewDataKeys(sess *session.Session, format string, opts options.Options) (DataKeys, error) {
+	switch format {
+	case FormatKMS:
+		return &kmsDataKeys{client: kms.New(sess, utils.GetKmsConfig(opts)), key_id: opts.KmsKeyId}, nil
+	case FormatTransit:
+		if opts.VaultTransitKey == "" {
+			return nil, errors.New("the batch is sealed with Vault transit, provide the transit key with --vault-transit-key")
+		}
+		client, err := vault.NewClient(opts)
+		if err != nil {
+			return nil, err
+		}
+		return &transitDataKeys{client: client, key: opts.VaultTransitKey}, nil
+	}
+	return nil, nil
+}
+
+// data keys from KMS, decrypt only needs the client since the wrapped key names the KMS key it was generated under
+type kmsDataKeys struct {
+	client kmsiface.KMSAPI
+	key_id string
+}
+
+func (d *kmsDataKeys) Generate() ([]byte, string, error) {
+	out, err := d.client.GenerateDataKey(&kms.GenerateDataKeyInput{
+		KeyId:   aws.String(d.key_id),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return out.Plaintext, base64.StdEncoding.EncodeToString(out.CiphertextBlob), nil
+}
+
+func (d *kmsDataKeys) Unwrap(wrapped string) ([]byte, error) {
+	blob, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data key - %s", err)
+	}
+	out, err := d.client.Decrypt(&kms.DecryptInput{CiphertextBlob: blob})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+// data keys from a Vault transit key, which unwraps them without the key ever leaving Vault
+type transitDataKeys struct {
+	client *vault.Client
+	key    string
+}
+
+func (d *transitDataKeys) Generate() ([]byte, string, error) {
+	return d.client.GenerateDataKey(d.key)
+}
+
+func (d *transitDataKeys) Unwrap(wrapped string) ([]byte, error) {
+	return d.client.DecryptDataKey(d.key, wrapped)
+}
+
+// The sealed stream starts with a header naming the format, the segment size and the nonce prefix.
+// Each segment is sealed on its own so files of any size stream through a fixed amount of memory.
+// Nonces are the prefix, the segment counter and a final flag, so segments can't be reordered, dropped or truncated unnoticed.
+const (
+	// marks objects of every envelope format, Vault transit ones included. The bytes predate transit support and name KMS,
+	// they are kept as they are so existing objects still open. The manifest's Format says which service wraps the data keys
+	envelopeMagic       = "S3S2KMS\x01"
+	envelopeSegmentSize = 64 * 1024
+	noncePrefixSize     = 7
+	envelopeHeaderSize  = len(envelopeMagic) + 4 + noncePrefixSize
+)
+
+type segmentCipher struct {
+	aead   cipher.AEAD
+	header []byte
+	nonce  []byte
+	count  uint32
+}
+
+func newSegmentCipher(data_key []byte, header []byte) (*segmentCipher, error) {
+	block, err := aes.NewCipher(data_key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	copy(nonce, header[len(envelopeMagic)+4:])
+	return &segmentCipher{aead: aead, header: header, nonce: nonce}, nil
+}
+
+// next returns the nonce of the following segment, the header is authenticated along with every segment
+func (s *segmentCipher) next(last bool) ([]byte, error) {
+	if s.count == ^uint32(0) {
+		return nil, errors.New("too many segments")
+	}
+	binary.BigEndian.PutUint32(s.nonce[noncePrefixSize:], s.count)
+	s.nonce[len(s.nonce)-1] = 0
+	if last {
+		s.nonce[len(s.nonce)-1] = 1
+	}
+	s.count++
+	return s.nonce, nil
+}
+
+// sealWriter holds back a full segment until it knows whether more follow, Close seals the final one
+type sealWriter struct {
+	out     io.Writer
+	segment *segmentCipher
+	buf     []byte
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(s.buf) == envelopeSegmentSize {
+			if err := s.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(s.buf[len(s.buf):envelopeSegmentSize], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (s *sealWriter) seal(last bool) error {
+	nonce, err := s.segment.next(last)
+	if err != nil {
+		return err
+	}
+	_, err = s.out.Write(s.segment.aead.Seal(nil, nonce, s.buf, s.segment.header))
+	s.buf = s.buf[:0]
+	return err
+}
+
+func (s *sealWriter) Close() error {
+	return s.seal(true)
+}
+
+// openReader reads back what sealWriter wrote, failing on any segment that was modified or is missing
+type openReader struct {
+	in      *bufio.Reader
+	segment *segmentCipher
+	plain   []byte
+	done    bool
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.plain) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+		sealed := make([]byte, envelopeSegmentSize+o.segment.aead.Overhead())
+		n, err := io.ReadFull(o.in, sealed)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		// the final segment is the one nothing follows
+		if _, err := o.in.Peek(1); err == io.EOF {
+			o.done = true
+		}
+		nonce, err := o.segment.next(o.done)
+		if err != nil {
+			return 0, err
+		}
+		if o.plain, err = o.segment.aead.Open(sealed[:0], nonce, sealed[:n], o.segment.header); err != nil {
+			return 0, errors.New("envelope segment failed authentication, the object is corrupt or incomplete")
+		}
+	}
+	n := copy(p, o.plain)
+	o.plain = o.plain[n:]
+	return n, nil
+}
+
+// NewEnvelopeWriter returns a writer that compresses everything written to it with codec and seals it under data_key into out.
+// Close must be called to seal the final segment, it does not close out.
+func NewEnvelopeWriter(data_key []byte, out io.Writer, codec string, Opts options.Options) (io.WriteCloser, error) {
+	header := make([]byte, envelopeHeaderSize)
+	copy(header, envelopeMagic)
+	binary.BigEndian.PutUint32(header[len(envelopeMagic):], envelopeSegmentSize)
+	if _, err := io.ReadFull(rand.Reader, header[len(envelopeMagic)+4:]); err != nil {
+		return nil, err
+	}
+
+	segment, err := newSegmentCipher(data_key, header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := out.Write(header); err != nil {
+		return nil, err
+	}
+	sealed := &sealWriter{out: out, segment: segment, buf: make([]byte, 0, envelopeSegmentSize)}
+
+	compressed, err := compress.NewWriter(codec, Opts.CompressionLevel, sealed)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptWriter{compressed: compressed, plain: sealed, armored: nopWriteCloser{out}}, nil
+}
+
+// NewEnvelopeReader returns the opened and decompressed contents of an object sealed by NewEnvelopeWriter
+func NewEnvelopeReader(data_key []byte, in io.Reader, codec string) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(in)
+	header := make([]byte, envelopeHeaderSize)
+	if _, err := io.ReadFull(buffered, header); err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(header, []byte(envelopeMagic)) {
+		return nil, errors.New("not an envelope encrypted object")
+	}
+	if size := binary.BigEndian.Uint32(header[len(envelopeMagic):]); size != envelopeSegmentSize {
+		return nil, fmt.Errorf("unsupported envelope segment size %d", size)
+	}
+
+	segment, err := newSegmentCipher(data_key, header)
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := compress.NewReader(codec, &openReader{in: buffered, segment: segment})
+	if err != nil {
+		return nil, err
+	}
+	return &decryptReader{Reader: compressed, compressed: compressed}, nil
+}
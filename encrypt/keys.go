@@ -0,0 +1,238 @@
+package encrypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	session "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	log "github.com/sirupsen/logrus"
+	aws_helpers "github.com/tempuslabs/s3s2/aws_helpers"
+	options "github.com/tempuslabs/s3s2/options"
+	utils "github.com/tempuslabs/s3s2/utils"
+	vault "github.com/tempuslabs/s3s2/vault"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// Reads key material from SSM when a parameter name is provided, otherwise from the local file.
+// Paths given as vault://<mount>/<path>#<field> are read from a Vault KV v2 secret instead.
+func readKey(sess *session.Session, ssm_name string, path string, opts options.Options) []byte {
+	// if provided SSM key, then fetch from SSM
+	if ssm_name != "" {
+		ssm_service := ssm.New(sess)
+		return []byte(aws_helpers.GetParameterValue(ssm_service, ssm_name, opts))
+	}
+
+	if vault.IsRef(path) {
+		client, err := vault.NewClient(opts)
+		utils.PanicIfError("Unable to connect to Vault - ", err)
+		value, err := client.ReadKV(path)
+		utils.PanicIfError("Unable to read key from Vault - ", err)
+		return []byte(value)
+	}
+
+	// if provided original filepath value, then use instead
+	data, err := os.ReadFile(path)
+	utils.PanicIfError("Unable to open key file - ", err)
+	return data
+}
+
+// Parses armored or binary key material. Each key may be a full keyring, as exported by gpg with its
+// user IDs and subkeys, or the lone key packet written by genkey.
+func readKeyRing(data []byte) (openpgp.EntityList, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		return parseKeys(data)
+	}
+
+	var keyring openpgp.EntityList
+	in := bytes.NewReader(data)
+	for {
+		block, err := armor.Decode(in)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := io.ReadAll(block.Body)
+		if err != nil {
+			return nil, err
+		}
+		keys, err := parseKeys(body)
+		if err != nil {
+			return nil, err
+		}
+		keyring = append(keyring, keys...)
+	}
+	if len(keyring) == 0 {
+		return nil, errors.New("no armored keys found")
+	}
+	return keyring, nil
+}
+
+func parseKeys(body []byte) (openpgp.EntityList, error) {
+	keyring, err := openpgp.ReadKeyRing(bytes.NewReader(body))
+	if err == nil {
+		return keyring, nil
+	}
+	// anything but the lone key packet genkey writes is a real keyring, its revocations and expiry can't be ignored
+	if e := bareKey(body); e != nil {
+		return openpgp.EntityList{e}, nil
+	}
+	return nil, err
+}
+
+// genkey RSA keys are a single primary key packet without user ID or self-signature, so they are wrapped in an entity of their own
+func bareKey(body []byte) *openpgp.Entity {
+	reader := packet.NewReader(bytes.NewReader(body))
+	pkt, err := reader.Next()
+	if err != nil {
+		return nil
+	}
+	if _, err := reader.Next(); err != io.EOF {
+		return nil
+	}
+
+	switch key := pkt.(type) {
+	case *packet.PrivateKey:
+		if !key.IsSubkey {
+			return createEntityFromKeys(&key.PublicKey, key)
+		}
+	case *packet.PublicKey:
+		if !key.IsSubkey {
+			return createEntityFromKeys(key, nil)
+		}
+	}
+	return nil
+}
+
+// checkKey refuses keys that have been revoked or have expired
+func checkKey(e *openpgp.Entity, now time.Time) error {
+	if e.Revoked(now) {
+		return fmt.Errorf("key %X has been revoked", e.PrimaryKey.KeyId)
+	}
+	if sig, _ := e.PrimarySelfSignature(); sig != nil && e.PrimaryKey.KeyExpired(sig, now) {
+		return fmt.Errorf("key %X has expired", e.PrimaryKey.KeyId)
+	}
+	return nil
+}
+
+// checkRecipient makes sure openpgp will find a usable encryption key or subkey, so share fails before uploading anything
+func checkRecipient(e *openpgp.Entity) error {
+	if err := checkKey(e, time.Now()); err != nil {
+		return err
+	}
+	config := getEncryptionConfig()
+	w, err := openpgp.Encrypt(io.Discard, []*openpgp.Entity{e}, nil, nil, &config)
+	if err != nil {
+		return fmt.Errorf("key %X - %s", e.PrimaryKey.KeyId, err)
+	}
+	return w.Close()
+}
+
+// GetPubKeys fetches the public key of every recipient of a share.
+// PubKey and SSMPubKey may each list several comma-separated keys, keys from both are used.
+// PubKey entries may also be https urls, their keys must match ReceiverKeyFingerprint.
+func GetPubKeys(sess *session.Session, opts options.Options) openpgp.EntityList {
+	if opts.SSMPubKey == "" && opts.PubKey == "" {
+		panic("You must provide a public key argument!")
+	}
+
+	var sources [][]byte
+	var keyrings []openpgp.EntityList
+	for _, path := range utils.SplitList(opts.PubKey) {
+		// keys published at a url are only trusted when they match a pinned fingerprint
+		if IsKeyURL(path) {
+			keyring, err := fetchPubKey(path, opts)
+			utils.PanicIfError("Unable to fetch public key - ", err)
+			keyrings = append(keyrings, keyring)
+			continue
+		}
+		sources = append(sources, readKey(sess, "", path, opts))
+	}
+	for _, ssm_name := range utils.SplitList(opts.SSMPubKey) {
+		sources = append(sources, readKey(sess, ssm_name, "", opts))
+	}
+	for _, data := range sources {
+		keyring, err := readKeyRing(data)
+		utils.PanicIfError("Unable to read public key - ", err)
+		keyrings = append(keyrings, keyring)
+	}
+
+	var recipients openpgp.EntityList
+	for _, keyring := range keyrings {
+		for _, e := range keyring {
+			utils.PanicIfError("Unable to encrypt to public key - ", checkRecipient(e))
+		}
+		recipients = append(recipients, keyring...)
+	}
+	log.Debugf("Encrypting to %d recipient keys", len(recipients))
+	return recipients
+}
+
+// GetPrivKey fetches the receiver's private keyring, any of its keys or subkeys may decrypt a message
+func GetPrivKey(sess *session.Session, opts options.Options) openpgp.EntityList {
+	if opts.SSMPrivKey == "" && opts.PrivKey == "" {
+		panic("You must provide a private key argument!")
+	}
+	keyring, err := readKeyRing(readKey(sess, opts.SSMPrivKey, opts.PrivKey, opts))
+	utils.PanicIfError("Unable to read private key - ", err)
+	utils.PanicIfError("Unable to read private key - ", unlockKeys(sess, keyring, opts))
+
+	if len(keyring.DecryptionKeys()) == 0 {
+		panic("No private decryption keys found, was a public key provided instead?")
+	}
+	return keyring
+}
+
+// Logic to fetch the sender's signing key, nil when share was not given one
+func GetSignKey(sess *session.Session, opts options.Options) *openpgp.Entity {
+	if opts.SSMSignKey == "" && opts.SignKey == "" {
+		return nil
+	}
+	keyring, err := readKeyRing(readKey(sess, opts.SSMSignKey, opts.SignKey, opts))
+	utils.PanicIfError("Unable to read sender private key - ", err)
+
+	signer := keyring[0]
+	if signer.PrivateKey == nil {
+		panic("The sender key has no private key, was a public key provided instead?")
+	}
+	utils.PanicIfError("Unable to read sender private key - ", unlockKeys(sess, openpgp.EntityList{signer}, opts))
+	utils.PanicIfError("Unable to sign with sender key - ", checkKey(signer, time.Now()))
+	return signer
+}
+
+// GetTrustedSenders reads the public keys of the senders decrypt accepts signatures from, nil when none are configured.
+// The file may hold any number of armored keys, either full keyrings or bare keys as written by genkey.
+// Revoked and expired keys are left out, so their signatures are not trusted.
+func GetTrustedSenders(opts options.Options) openpgp.EntityList {
+	if opts.TrustedSenders == "" {
+		return nil
+	}
+
+	keyring, err := readKeyRing(readKey(nil, "", opts.TrustedSenders, opts))
+	utils.PanicIfError("Unable to read trusted sender keys - ", err)
+
+	var senders openpgp.EntityList
+	for _, e := range keyring {
+		if err := checkKey(e, time.Now()); err != nil {
+			log.Warnf("Ignoring trusted sender - %s", err)
+			continue
+		}
+		senders = append(senders, e)
+	}
+
+	if len(senders) == 0 {
+		panic("No usable public keys found in trusted senders file '" + opts.TrustedSenders + "'")
+	}
+	log.Debugf("Loaded %d trusted sender keys", len(senders))
+	return senders
+}
@@ -0,0 +1,56 @@
+package encrypt
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readTestKeyRing(t *testing.T, name string) openpgp.EntityList {
+	data, err := os.ReadFile("../tests/resources/" + name)
+	require.NoError(t, err)
+	keyring, err := readKeyRing(data)
+	require.NoError(t, err)
+	return keyring
+}
+
+// the lone key packets genkey writes are still wrapped in an entity of their own
+func TestReadKeyRingWrapsGenkeyKeys(t *testing.T) {
+	for _, name := range []string{"testkey.pubkey", "testkey.privkey"} {
+		keyring := readTestKeyRing(t, name)
+		require.Len(t, keyring, 1)
+		assert.NoError(t, checkRecipient(keyring[0]), name)
+	}
+}
+
+// keyrings whose self-signatures are missing are refused, rather than wrapped in a made up self-signature that
+// would drop their revocation, expiry and subkeys
+func TestReadKeyRingRefusesUnsignedKeyrings(t *testing.T) {
+	for name, reason := range map[string]string{"revoked.pubkey": "has been revoked", "expired.pubkey": "has expired"} {
+		e := readTestKeyRing(t, name)[0]
+		assert.ErrorContains(t, checkKey(e, time.Now()), reason)
+
+		var stripped bytes.Buffer
+		require.NoError(t, e.PrimaryKey.Serialize(&stripped))
+		for _, identity := range e.Identities {
+			require.NoError(t, identity.UserId.Serialize(&stripped))
+		}
+		for _, subkey := range e.Subkeys {
+			require.NoError(t, subkey.PublicKey.Serialize(&stripped))
+		}
+		_, err := readKeyRing(stripped.Bytes())
+		assert.Error(t, err, name)
+
+		// nor is a bare primary key followed by anything else
+		var extra bytes.Buffer
+		require.NoError(t, e.PrimaryKey.Serialize(&extra))
+		require.NoError(t, e.Subkeys[0].PublicKey.Serialize(&extra))
+		_, err = readKeyRing(extra.Bytes())
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,113 @@
+package encrypt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	session "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	log "github.com/sirupsen/logrus"
+	aws_helpers "github.com/tempuslabs/s3s2/aws_helpers"
+	options "github.com/tempuslabs/s3s2/options"
+	"golang.org/x/term"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// GetPassphrase reads the passphrase from SSM, an environment variable or a file descriptor, in that order.
+// Without any of them it is prompted for on the terminal, confirm asks for it a second time.
+func GetPassphrase(sess *session.Session, opts options.Options, prompt string, confirm bool) ([]byte, error) {
+	switch {
+	case opts.SSMPassphrase != "":
+		return []byte(aws_helpers.GetParameterValue(ssm.New(sess), opts.SSMPassphrase, opts)), nil
+	case opts.PassphraseEnv != "":
+		passphrase, ok := os.LookupEnv(opts.PassphraseEnv)
+		if !ok {
+			return nil, fmt.Errorf("environment variable '%s' is not set", opts.PassphraseEnv)
+		}
+		return []byte(passphrase), nil
+	case opts.PassphraseFd != "":
+		return readPassphraseFd(opts.PassphraseFd)
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, errors.New("no passphrase provided and stdin is not a terminal to prompt on")
+	}
+	fmt.Fprint(os.Stderr, prompt+": ")
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil || !confirm {
+		return passphrase, err
+	}
+
+	fmt.Fprint(os.Stderr, "Repeat passphrase: ")
+	repeated, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	if string(repeated) != string(passphrase) {
+		return nil, errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+// Reads the first line written to an inherited file descriptor, like gpg's --passphrase-fd
+func readPassphraseFd(fd_arg string) ([]byte, error) {
+	fd, err := strconv.Atoi(fd_arg)
+	if err != nil || fd < 0 {
+		return nil, fmt.Errorf("invalid passphrase file descriptor '%s'", fd_arg)
+	}
+	f := os.NewFile(uintptr(fd), "passphrase")
+	if f == nil {
+		return nil, fmt.Errorf("invalid passphrase file descriptor %d", fd)
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// unlockKeys decrypts the passphrase-protected keys of keyring, only asking for the passphrase when one is protected
+func unlockKeys(sess *session.Session, keyring openpgp.EntityList, opts options.Options) error {
+	var locked []*packet.PrivateKey
+	for _, e := range keyring {
+		if e.PrivateKey != nil && e.PrivateKey.Encrypted && !e.PrivateKey.Dummy() {
+			locked = append(locked, e.PrivateKey)
+		}
+		for _, subkey := range e.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted && !subkey.PrivateKey.Dummy() {
+				locked = append(locked, subkey.PrivateKey)
+			}
+		}
+	}
+	if len(locked) == 0 {
+		return nil
+	}
+
+	passphrase, err := GetPassphrase(sess, opts, "Passphrase for private key", false)
+	if err != nil {
+		return fmt.Errorf("private key is passphrase-protected - %s", err)
+	}
+	for _, key := range locked {
+		// genkey keys are their own subkey, so the same key can be listed twice
+		if !key.Encrypted {
+			continue
+		}
+		if err := key.Decrypt(passphrase); err != nil {
+			return fmt.Errorf("unable to unlock key %X, is the passphrase correct? - %s", key.KeyId, err)
+		}
+	}
+	log.Debugf("Unlocked %d passphrase-protected keys", len(locked))
+	return nil
+}
@@ -0,0 +1,221 @@
+package encrypt
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+)
+
+// tag of the packets holding a message's session key encrypted to one recipient
+const publicKeyEncryptedKeyTag = 1
+
+// readPacket reads the next packet of a message whole, returning its tag. Only the encrypted data packet
+// that ends a message may have a partial length, it is reported with a nil packet so the caller can copy the rest as is.
+func readPacket(in *bufio.Reader) (int, []byte, error) {
+	header, err := in.Peek(1)
+	if err != nil {
+		return 0, nil, err
+	}
+	if header[0]&0x80 == 0 {
+		return 0, nil, errors.New("not an OpenPGP message")
+	}
+
+	var tag, header_length int
+	var body_length int64
+	if header[0]&0x40 != 0 {
+		// new format, the length is encoded in one, two or five octets
+		tag = int(header[0] & 0x3f)
+		if header, err = in.Peek(2); err != nil {
+			return 0, nil, err
+		}
+		switch l := header[1]; {
+		case l < 192:
+			header_length, body_length = 2, int64(l)
+		case l < 224:
+			if header, err = in.Peek(3); err != nil {
+				return 0, nil, err
+			}
+			header_length, body_length = 3, (int64(l)-192)<<8+int64(header[2])+192
+		case l == 255:
+			if header, err = in.Peek(6); err != nil {
+				return 0, nil, err
+			}
+			header_length = 6
+			body_length = int64(header[2])<<24 | int64(header[3])<<16 | int64(header[4])<<8 | int64(header[5])
+		default:
+			return tag, nil, nil
+		}
+	} else {
+		// old format, the length type is in the low bits of the tag octet
+		tag = int(header[0]&0x3f) >> 2
+		length_octets := map[byte]int{0: 1, 1: 2, 2: 4}[header[0]&0x03]
+		if length_octets == 0 {
+			return tag, nil, nil
+		}
+		if header, err = in.Peek(1 + length_octets); err != nil {
+			return 0, nil, err
+		}
+		header_length = 1 + length_octets
+		for _, b := range header[1:header_length] {
+			body_length = body_length<<8 | int64(b)
+		}
+	}
+
+	if tag != publicKeyEncryptedKeyTag {
+		return tag, nil, nil
+	}
+	raw := make([]byte, int64(header_length)+body_length)
+	if _, err := io.ReadFull(in, raw); err != nil {
+		return 0, nil, err
+	}
+	return tag, raw, nil
+}
+
+// decryptSessionKey recovers the session key from a recipient's encrypted key with any matching key of keyring
+func decryptSessionKey(keyring openpgp.EntityList, ek *packet.EncryptedKey) bool {
+	config := getEncryptionConfig()
+	for _, k := range keyring.DecryptionKeys() {
+		// a key ID of 0 hides the recipient, every key has to be tried
+		if ek.KeyId != 0 && k.PublicKey.KeyId != ek.KeyId {
+			continue
+		}
+		if k.PrivateKey.Encrypted {
+			continue
+		}
+		if err := ek.Decrypt(k.PrivateKey, &config); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// EncryptedTo returns the IDs of the keys a message's session key is encrypted to, reading no further than the session keys.
+// format is the encoding recorded in the manifest, when empty it is detected from the message itself.
+func EncryptedTo(in io.Reader, format string) ([]uint64, error) {
+	buffered := bufio.NewReader(in)
+	if format == "" {
+		format = detectFormat(buffered)
+	}
+	if format != FormatBinary {
+		block, err := armor.Decode(buffered)
+		if err != nil {
+			return nil, err
+		}
+		buffered = bufio.NewReader(block.Body)
+	}
+
+	var key_ids []uint64
+	for {
+		tag, raw, err := readPacket(buffered)
+		if err != nil {
+			return nil, err
+		}
+		if tag != publicKeyEncryptedKeyTag {
+			return key_ids, nil
+		}
+		p, err := packet.Read(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		if ek, ok := p.(*packet.EncryptedKey); ok {
+			key_ids = append(key_ids, ek.KeyId)
+		}
+	}
+}
+
+// RekeyMessage copies a message from in to out with its session key re-encrypted from the keys of keyring to every one of the recipients.
+// Only the session key is touched, the encrypted data, and any sender signature within it, is copied as is.
+// Recipients keyring can't decrypt for, such as other receivers of the batch, are kept.
+// format is the encoding recorded in the manifest, when empty it is detected from the message itself, and out is written in the same one.
+// Messages already encrypted to all of the recipients and none of keyring's keys are copied unchanged, and false is returned.
+func RekeyMessage(keyring openpgp.EntityList, to openpgp.EntityList, in io.Reader, out io.Writer, format string) (bool, error) {
+	if len(to) == 0 {
+		return false, errors.New("no recipient public keys")
+	}
+
+	buffered := bufio.NewReader(in)
+	if format == "" {
+		format = detectFormat(buffered)
+	}
+
+	var w io.WriteCloser = nopWriteCloser{out}
+	if format != FormatBinary {
+		block, err := armor.Decode(buffered)
+		if err != nil {
+			return false, err
+		}
+		buffered = bufio.NewReader(block.Body)
+		if w, err = armor.Encode(out, "Message", make(map[string]string)); err != nil {
+			return false, err
+		}
+	}
+
+	var kept [][]byte
+	var session *packet.EncryptedKey
+	recipients := make(map[uint64]bool)
+	for {
+		tag, raw, err := readPacket(buffered)
+		if err != nil {
+			return false, err
+		}
+		if tag != publicKeyEncryptedKeyTag {
+			break
+		}
+
+		p, err := packet.Read(bytes.NewReader(raw))
+		if err != nil {
+			return false, err
+		}
+		ek, ok := p.(*packet.EncryptedKey)
+		if !ok {
+			return false, fmt.Errorf("unexpected %T in place of an encrypted session key", p)
+		}
+		if decryptSessionKey(keyring, ek) {
+			session = ek
+			continue
+		}
+		kept = append(kept, raw)
+		recipients[ek.KeyId] = true
+	}
+
+	config := getEncryptionConfig()
+	var added []*packet.PublicKey
+	for _, e := range to {
+		key, ok := e.EncryptionKey(config.Now())
+		if !ok {
+			return false, fmt.Errorf("key %X has no usable encryption key", e.PrimaryKey.KeyId)
+		}
+		if !recipients[key.PublicKey.KeyId] {
+			added = append(added, key.PublicKey)
+		}
+	}
+
+	if session == nil && len(added) > 0 {
+		return false, errors.New("the message is not encrypted to any of the old private keys")
+	}
+
+	for _, raw := range kept {
+		if _, err := w.Write(raw); err != nil {
+			return false, err
+		}
+	}
+	for _, pub := range added {
+		if err := packet.SerializeEncryptedKeyAEAD(w, pub, session.CipherFunc, session.Version == 6, session.Key, &config); err != nil {
+			return false, err
+		}
+	}
+	log.Debugf("Re-encrypted session key to %d recipient keys, kept %d", len(added), len(kept))
+
+	if _, err := io.Copy(w, buffered); err != nil {
+		return false, err
+	}
+	return session != nil, w.Close()
+}
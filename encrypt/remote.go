@@ -0,0 +1,185 @@
+package encrypt
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	log "github.com/sirupsen/logrus"
+
+	options "github.com/tempuslabs/s3s2/options"
+	utils "github.com/tempuslabs/s3s2/utils"
+)
+
+// published keys are small, anything larger is not a key
+const maxKeySize = 1 << 20
+
+// IsKeyURL reports whether a key option is fetched from a url instead of read from a local file
+func IsKeyURL(s string) bool {
+	return strings.HasPrefix(s, "https://") || strings.HasPrefix(s, "http://")
+}
+
+// Fingerprint formats the fingerprint of a key's primary key the way pins are given
+func Fingerprint(e *openpgp.Entity) string {
+	return fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+}
+
+// parsePins accepts fingerprints as printed by gpg, with or without spaces, colons or a 0x prefix
+func parsePins(list string) map[string]bool {
+	pins := make(map[string]bool)
+	for _, pin := range utils.SplitList(list) {
+		pin = strings.NewReplacer(" ", "", ":", "").Replace(strings.ToUpper(pin))
+		pins[strings.TrimPrefix(pin, "0X")] = true
+	}
+	return pins
+}
+
+// checkPinned refuses any key that is not pinned, so a tampered key is never encrypted to
+func checkPinned(keyring openpgp.EntityList, pins map[string]bool, url string) error {
+	for _, e := range keyring {
+		if !pins[Fingerprint(e)] {
+			return fmt.Errorf("key %s served by '%s' does not match any pinned fingerprint", Fingerprint(e), url)
+		}
+	}
+	return nil
+}
+
+// keys are cached per url under the user's cache directory
+func keyCachePath(url string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "s3s2", "keys", fmt.Sprintf("%x.asc", sha256.Sum256([]byte(url)))), nil
+}
+
+// readCachedKey returns the cached keys of url when they are still pinned, along with the time they were fetched
+func readCachedKey(cache_path string, pins map[string]bool, url string) (openpgp.EntityList, time.Time) {
+	info, err := os.Stat(cache_path)
+	if err != nil {
+		return nil, time.Time{}
+	}
+	data, err := os.ReadFile(cache_path)
+	if err != nil {
+		return nil, time.Time{}
+	}
+	keyring, err := readKeyRing(data)
+	if err != nil || checkPinned(keyring, pins, url) != nil {
+		log.Warnf("Ignoring cached key for '%s' - it no longer matches the pinned fingerprints", url)
+		return nil, time.Time{}
+	}
+	return keyring, info.ModTime()
+}
+
+// downloadKey fetches a published key over https, trusting the CA bundle when one is configured
+func downloadKey(url string, opts options.Options) ([]byte, error) {
+	if !strings.HasPrefix(url, "https://") {
+		return nil, fmt.Errorf("'%s' must be an https url", url)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if opts.CaBundle != "" {
+		bundle, err := os.ReadFile(opts.CaBundle)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates found in '%s'", opts.CaBundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   30 * time.Second,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Scheme != "https" {
+				return errors.New("refusing to follow a redirect away from https")
+			}
+			return nil
+		},
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("'%s' returned %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxKeySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxKeySize {
+		return nil, fmt.Errorf("'%s' returned more than %d bytes, not a public key", url, maxKeySize)
+	}
+	return data, nil
+}
+
+// fetchPubKey returns the receiver keys published at url. Every key must match one of the pinned fingerprints.
+// Keys are cached for opts.KeyCacheTtl. When url can't be reached a cached copy is used, as long as it
+// is no older than opts.KeyCacheMaxStale, so a key rotated or revoked upstream isn't used forever.
+func fetchPubKey(url string, opts options.Options) (openpgp.EntityList, error) {
+	pins := parsePins(opts.ReceiverKeyFingerprint)
+	if len(pins) == 0 {
+		return nil, fmt.Errorf("'%s' is fetched from a url, its fingerprint must be pinned", url)
+	}
+
+	cache_path, err := keyCachePath(url)
+	if err != nil {
+		log.Warnf("Not caching the key from '%s' - %s", url, err)
+	}
+	var cached openpgp.EntityList
+	var fetched time.Time
+	if cache_path != "" {
+		cached, fetched = readCachedKey(cache_path, pins, url)
+		if cached != nil && time.Since(fetched) < opts.KeyCacheTtl {
+			log.Debugf("Using the key from '%s' cached at %s", url, fetched.Format(time.RFC3339))
+			return cached, nil
+		}
+	}
+
+	log.Debugf("Fetching public key from '%s'", url)
+	data, err := downloadKey(url, opts)
+	if err != nil {
+		if cached == nil {
+			return nil, err
+		}
+		age := time.Since(fetched).Round(time.Second)
+		if age > opts.KeyCacheMaxStale {
+			return nil, fmt.Errorf("unable to fetch public key from '%s' and the copy cached %s ago is older than --key-cache-max-stale allows - %s", url, age, err)
+		}
+		log.Warnf("Unable to fetch public key from '%s', using the copy cached %s ago - %s", url, age, err)
+		return cached, nil
+	}
+	keyring, err := readKeyRing(data)
+	if err != nil {
+		return nil, err
+	}
+	// a key that fails its pin is never cached, nor is the cached copy used in its place
+	if err := checkPinned(keyring, pins, url); err != nil {
+		return nil, err
+	}
+
+	if cache_path != "" {
+		err := os.MkdirAll(filepath.Dir(cache_path), 0700)
+		if err == nil {
+			err = os.WriteFile(cache_path, data, 0600)
+		}
+		if err != nil {
+			log.Warnf("Unable to cache the key from '%s' - %s", url, err)
+		}
+	}
+	return keyring, nil
+}
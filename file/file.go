@@ -1,12 +1,18 @@
 package file
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/csv"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -16,7 +22,16 @@ import (
 
 type File struct {
 	Name string
-	// additional attributes as needed
+	// recorded by share from the source file so decrypt can prove it arrived intact
+	Size        int64
+	ModTime     time.Time
+	Sha256      string `json:",omitempty"`
+	// codec the file was compressed with before it was encrypted, empty for files shared before it could be chosen
+	Compression string `json:",omitempty"`
+	// KMS-wrapped data key the file was sealed with, only for batches shared with KMS envelope encryption
+	DataKey     string `json:",omitempty"`
+	// opaque name the object is stored under instead of the file name, only for batches shared with --opaque-names
+	Key         string `json:",omitempty"`
 }
 
 // Specify the filepath of the original version of the file
@@ -31,9 +46,124 @@ func (f *File) GetZipName(directory string) string {
 
 // Specify the filepath of the encrypted version of the file
 func (f *File) GetEncryptedName(directory string) string {
+    if f.Key != "" {
+        return filepath.Join(directory, f.Key + ".zip.gpg")
+    }
     return filepath.Join(directory, f.Name + ".zip.gpg")
 }
 
+// NewKey gives the file a random opaque name to store its object under, so the object key reveals nothing about the file
+func (f *File) NewKey() error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	f.Key = hex.EncodeToString(id)
+	return nil
+}
+
+// Source is a source file opened for sharing, see File.Open
+type Source struct {
+	in   *os.File
+	info os.FileInfo
+	sum  hash.Hash
+	read int64
+	dest *File
+}
+
+// Open opens the source file to share it, recording its size and modification time from the open handle.
+// When hashing, the SHA-256 of everything read from the source is recorded on the file once it is closed,
+// so the file is read only once and the checksum is of exactly what was shared.
+func (f *File) Open(source_path string, hash bool) (*Source, error) {
+	in, err := os.Open(source_path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := in.Stat()
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	f.Size = info.Size()
+	f.ModTime = info.ModTime()
+	f.Sha256 = ""
+
+	source := &Source{in: in, info: info, dest: f}
+	if hash {
+		source.sum = sha256.New()
+	}
+	return source, nil
+}
+
+func (s *Source) Read(p []byte) (int, error) {
+	n, err := s.in.Read(p)
+	s.read += int64(n)
+	if s.sum != nil {
+		s.sum.Write(p[:n])
+	}
+	return n, err
+}
+
+// Stat returns the details of the source file as it was opened
+func (s *Source) Stat() (os.FileInfo, error) {
+	return s.info, nil
+}
+
+// Close records the size, and checksum when hashing, of what was read from the source
+func (s *Source) Close() error {
+	s.dest.Size = s.read
+	if s.sum != nil {
+		s.dest.Sha256 = hex.EncodeToString(s.sum.Sum(nil))
+	}
+	return s.in.Close()
+}
+
+// Stamped reports whether share recorded the source file's details, older manifests only carry names
+func (f *File) Stamped() bool {
+	return !f.ModTime.IsZero()
+}
+
+// Verify compares a decrypted copy of the file against the size and checksum share recorded for it
+func (f *File) Verify(path string) error {
+	if !f.Stamped() {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() != f.Size {
+		return fmt.Errorf("size mismatch - expected %d bytes, found %d", f.Size, info.Size())
+	}
+
+	if f.Sha256 == "" {
+		return nil
+	}
+	sum, err := Sha256(path)
+	if err != nil {
+		return err
+	}
+	if sum != f.Sha256 {
+		return fmt.Errorf("sha256 mismatch - expected %s, found %s", f.Sha256, sum)
+	}
+	return nil
+}
+
+// Sha256 returns the hex encoded SHA-256 of a file's contents
+func Sha256(path string) (string, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, in); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
 // Break an array of objects into an array of chunks of n size
 func ChunkArray(in_array []File, chunk_size int) [][]File {
 
@@ -103,7 +233,7 @@ func GetFileStructsFromDir(directory string, opts options.Options) ([]File, []Fi
         panic(err)
     }
 
-    log.Debugf("Identified metadata-files '%s'...", file_structs_metadata)
+    log.Debugf("Identified metadata-files '%v'...", file_structs_metadata)
 
     return file_structs, file_structs_metadata, err
 
@@ -155,7 +285,7 @@ func GetFileStructsFromCsv(indexPath string, dateFolder string, opts options.Opt
         panic(err)
     }
 
-    log.Debugf("Identified metadata-files '%s'...", file_structs_metadata)
+    log.Debugf("Identified metadata-files '%v'...", file_structs_metadata)
 
     return file_structs, file_structs_metadata, err
 
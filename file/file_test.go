@@ -0,0 +1,55 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// what was read is recorded, even when the file changes after it was opened
+func TestOpenRecordsWhatWasRead(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "a.txt")
+	require.NoError(t, os.WriteFile(source, []byte("top level file"), 0600))
+
+	fs := File{Name: "a.txt"}
+	opened, err := fs.Open(source, true)
+	require.NoError(t, err)
+	info, err := opened.Stat()
+	require.NoError(t, err)
+	assert.Equal(t, info.ModTime(), fs.ModTime)
+
+	appended, err := os.OpenFile(source, os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = appended.WriteString(" grown")
+	require.NoError(t, err)
+	require.NoError(t, appended.Close())
+
+	read, err := io.ReadAll(opened)
+	require.NoError(t, err)
+	require.NoError(t, opened.Close())
+
+	sum := sha256.Sum256(read)
+	assert.Equal(t, int64(len(read)), fs.Size)
+	assert.Equal(t, hex.EncodeToString(sum[:]), fs.Sha256)
+}
+
+func TestOpenWithoutHash(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "a.txt")
+	require.NoError(t, os.WriteFile(source, []byte("top level file"), 0600))
+
+	fs := File{Name: "a.txt", Sha256: "stale"}
+	opened, err := fs.Open(source, false)
+	require.NoError(t, err)
+	_, err = io.Copy(io.Discard, opened)
+	require.NoError(t, err)
+	require.NoError(t, opened.Close())
+
+	assert.Equal(t, int64(14), fs.Size)
+	assert.Empty(t, fs.Sha256)
+}
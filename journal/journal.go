@@ -0,0 +1,273 @@
+package journal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+
+	jsoniter "github.com/json-iterator/go"
+	log "github.com/sirupsen/logrus"
+
+	file "github.com/tempuslabs/s3s2/file"
+	options "github.com/tempuslabs/s3s2/options"
+)
+
+// Header identifies the share run a journal belongs to, a resumed run must share the same inputs
+type Header struct {
+	Fnuuid        string `json:"fnuuid"`
+	DateFolder    string `json:"date_folder"`
+	Org           string `json:"org"`
+	Prefix        string `json:"prefix"`
+	Directory     string `json:"directory"`
+	ShareFromList string `json:"share_from_list"`
+	Binary        bool   `json:"binary,omitempty"`
+	KmsKeyId      string `json:"kms_key_id,omitempty"`
+	VaultTransitKey string `json:"vault_transit_key,omitempty"`
+	OpaqueNames   bool   `json:"opaque_names,omitempty"`
+}
+
+// entry is a single line of the journal, only the fields relevant to the event are set
+type entry struct {
+	Header   *Header    `json:"header,omitempty"`
+	Batch    string     `json:"batch,omitempty"`
+	File     *file.File `json:"file,omitempty"`
+	Trigger  string     `json:"trigger,omitempty"`
+	Complete bool       `json:"complete,omitempty"`
+}
+
+// Journal is an append-only record of what a share run has uploaded and to which batch folder.
+// Every event is written as its own json line as it happens, so an interrupted run leaves a usable journal behind.
+// A nil journal records nothing, which is what share uses when there is nowhere to keep one.
+type Journal struct {
+	Path   string
+	Header Header
+
+	// batch folders in the order they were started, the last one is the batch in progress
+	Batches   []string
+	Uploaded  map[string][]file.File
+	Triggered map[string]bool
+	Complete  bool
+
+	mu   sync.Mutex
+	out  *os.File
+	seen map[string]map[string]bool
+}
+
+func newJournal(path string) *Journal {
+	return &Journal{
+		Path:      path,
+		Uploaded:  make(map[string][]file.File),
+		Triggered: make(map[string]bool),
+		seen:      make(map[string]map[string]bool),
+	}
+}
+
+// Create starts a new journal at path for a share run
+func Create(path string, fnuuid string, date_folder string, opts options.Options) (*Journal, error) {
+	j := newJournal(path)
+	j.Header = Header{
+		Fnuuid:        fnuuid,
+		DateFolder:    date_folder,
+		Org:           opts.Org,
+		Prefix:        opts.Prefix,
+		Directory:     opts.Directory,
+		ShareFromList: opts.ShareFromList,
+		Binary:        opts.Binary,
+		KmsKeyId:      opts.KmsKeyId,
+		VaultTransitKey: opts.VaultTransitKey,
+		OpaqueNames:   opts.OpaqueNames,
+	}
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	j.out = out
+
+	log.Debugf("Created journal '%s'", path)
+	return j, j.write(entry{Header: &j.Header})
+}
+
+// Open replays an existing journal so its run can be resumed, further events are appended to it
+func Open(path string) (*Journal, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	j := newJournal(path)
+	header_read := false
+	torn := false
+
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if len(line) == 0 {
+			continue
+		}
+		var e entry
+		if err := jsoniter.Unmarshal(line, &e); err != nil {
+			// a run killed mid-write can only leave its very last line incomplete
+			if i == len(lines)-1 {
+				torn = true
+				break
+			}
+			return nil, fmt.Errorf("journal '%s' is corrupt - %s", path, err)
+		}
+
+		switch {
+		case e.Header != nil:
+			j.Header = *e.Header
+			header_read = true
+		case e.File != nil:
+			j.record(e.Batch, *e.File)
+		case e.Batch != "":
+			j.Batches = append(j.Batches, e.Batch)
+		case e.Trigger != "":
+			j.Triggered[e.Trigger] = true
+		case e.Complete:
+			j.Complete = true
+		}
+	}
+	if !header_read || len(j.Batches) == 0 {
+		return nil, fmt.Errorf("journal '%s' does not describe a share run", path)
+	}
+
+	j.out, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = sealTornLine(j.out, data, torn); err != nil {
+		return nil, err
+	}
+	log.Debugf("Replayed journal '%s' - %d batches, current batch '%s'", path, len(j.Batches), j.CurrentBatch())
+	return j, nil
+}
+
+// sealTornLine cuts off a line a crash left incomplete, or ends one it left unterminated, so appended events start on a line of their own
+func sealTornLine(out *os.File, data []byte, torn bool) error {
+	if torn {
+		return out.Truncate(int64(bytes.LastIndexByte(data, '\n') + 1))
+	}
+	if len(data) == 0 || data[len(data)-1] == '\n' {
+		return nil
+	}
+	_, err := out.Write([]byte("\n"))
+	return err
+}
+
+func (j *Journal) record(batch string, fs file.File) {
+	if j.seen[batch] == nil {
+		j.seen[batch] = make(map[string]bool)
+	}
+	if !j.seen[batch][fs.Name] {
+		j.seen[batch][fs.Name] = true
+		j.Uploaded[batch] = append(j.Uploaded[batch], fs)
+	}
+}
+
+// lines are not synced to disk individually, the journal needs to survive a crashed process rather than a crashed host
+func (j *Journal) write(e entry) error {
+	line, err := jsoniter.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = j.out.Write(append(line, '\n'))
+	return err
+}
+
+// CurrentBatch is the batch folder the run was writing to when the journal was last updated
+func (j *Journal) CurrentBatch() string {
+	if len(j.Batches) == 0 {
+		return ""
+	}
+	return j.Batches[len(j.Batches)-1]
+}
+
+// Has reports whether a file was uploaded to the given batch folder
+func (j *Journal) Has(batch string, name string) bool {
+	if j == nil {
+		return false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.seen[batch][name]
+}
+
+// Split separates files already uploaded to any batch folder from those still to be sent
+func (j *Journal) Split(file_structs []file.File) ([]file.File, []file.File) {
+	if j == nil {
+		return file_structs, nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var pending []file.File
+	var uploaded []file.File
+	for _, fs := range file_structs {
+		done := false
+		for _, batch := range j.Batches {
+			if j.seen[batch][fs.Name] {
+				done = true
+				break
+			}
+		}
+		if done {
+			uploaded = append(uploaded, fs)
+		} else {
+			pending = append(pending, fs)
+		}
+	}
+	return pending, uploaded
+}
+
+// StartBatch records that files are now being sent to a new batch folder
+func (j *Journal) StartBatch(batch string) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Batches = append(j.Batches, batch)
+	return j.write(entry{Batch: batch})
+}
+
+// RecordUpload records that a file was successfully uploaded to a batch folder
+func (j *Journal) RecordUpload(batch string, fs file.File) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.record(batch, fs)
+	return j.write(entry{Batch: batch, File: &fs})
+}
+
+// RecordTrigger records that the lambda trigger was sent for a batch folder
+func (j *Journal) RecordTrigger(batch string) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Triggered[batch] = true
+	return j.write(entry{Trigger: batch})
+}
+
+// RecordComplete marks the run as finished, resuming it again is a no-op
+func (j *Journal) RecordComplete() error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Complete = true
+	return j.write(entry{Complete: true})
+}
+
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.out.Close()
+}
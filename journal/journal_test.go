@@ -0,0 +1,60 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	file "github.com/tempuslabs/s3s2/file"
+	options "github.com/tempuslabs/s3s2/options"
+)
+
+// a run resumed from a journal with a torn last line appends after it, so the journal can be resumed again
+func TestOpenSealsTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+
+	j, err := Create(path, "20240101120000", "20240101", options.Options{Org: "org", Prefix: "clinical"})
+	require.NoError(t, err)
+	require.NoError(t, j.StartBatch("clinical_0"))
+	require.NoError(t, j.RecordUpload("clinical_0", file.File{Name: "a.txt"}))
+	require.NoError(t, j.Close())
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"batch":"clinical_0","fi`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	j, err = Open(path)
+	require.NoError(t, err)
+	require.NoError(t, j.RecordUpload("clinical_0", file.File{Name: "b.txt"}))
+	require.NoError(t, j.Close())
+
+	j, err = Open(path)
+	require.NoError(t, err)
+	defer j.Close()
+	assert.True(t, j.Has("clinical_0", "a.txt"))
+	assert.True(t, j.Has("clinical_0", "b.txt"))
+}
+
+// a journal that stops cleanly is left as it is
+func TestOpenLeavesCompleteLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+
+	j, err := Create(path, "20240101120000", "20240101", options.Options{Org: "org", Prefix: "clinical"})
+	require.NoError(t, err)
+	require.NoError(t, j.StartBatch("clinical_0"))
+	require.NoError(t, j.Close())
+	before, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	j, err = Open(path)
+	require.NoError(t, err)
+	require.NoError(t, j.Close())
+	after, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+}
@@ -0,0 +1,96 @@
+package journal
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	log "github.com/sirupsen/logrus"
+)
+
+// Decrypted describes a file as it was left on disk once decrypt finished writing it
+type Decrypted struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Progress is an append-only record of the files a decrypt has finished, so a rerun can skip them
+type Progress struct {
+	Path string
+
+	mu   sync.Mutex
+	out  *os.File
+	done map[string]Decrypted
+}
+
+// OpenProgress replays the progress file at path if there is one, and appends to it from then on
+func OpenProgress(path string) (*Progress, error) {
+	p := &Progress{Path: path, done: make(map[string]Decrypted)}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		var d Decrypted
+		// a line torn by a crash only costs that one file being decrypted again
+		if jsoniter.Unmarshal(line, &d) == nil && d.Name != "" {
+			p.done[d.Name] = d
+		}
+	}
+	if len(data) > 0 {
+		log.Debugf("Replayed progress file '%s' - %d files already decrypted", path, len(p.done))
+	}
+
+	p.out, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = sealTornLine(p.out, data, false); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Record notes that name was fully decrypted to output_path
+func (p *Progress) Record(name string, output_path string) error {
+	info, err := os.Stat(output_path)
+	if err != nil {
+		return err
+	}
+	d := Decrypted{Name: name, Size: info.Size(), ModTime: info.ModTime()}
+
+	line, err := jsoniter.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done[name] = d
+	_, err = p.out.Write(append(line, '\n'))
+	return err
+}
+
+// Done reports whether name was recorded as decrypted and output_path is still exactly what was written
+func (p *Progress) Done(name string, output_path string) bool {
+	p.mu.Lock()
+	d, ok := p.done[name]
+	p.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	info, err := os.Stat(output_path)
+	if err != nil {
+		return false
+	}
+	return info.Size() == d.Size && info.ModTime().Equal(d.ModTime)
+}
+
+func (p *Progress) Close() error {
+	return p.out.Close()
+}
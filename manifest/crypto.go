@@ -0,0 +1,175 @@
+package manifest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+
+	encrypt "github.com/tempuslabs/s3s2/encrypt"
+	options "github.com/tempuslabs/s3s2/options"
+	storage "github.com/tempuslabs/s3s2/storage"
+	utils "github.com/tempuslabs/s3s2/utils"
+)
+
+// Crypto records how the objects of a batch were encrypted, so decrypt can tell whether its keys will do before downloading any
+type Crypto struct {
+	// the keys every object is encrypted to, empty for envelope batches
+	Recipients []Recipient `json:",omitempty"`
+	// the KMS or Vault transit key of envelope batches
+	KeyId       string `json:",omitempty"`
+	Cipher      string
+	Hash        string `json:",omitempty"`
+	Compression string
+	Armored     bool
+	// fingerprint of the sender key, every object is signed with it except in envelope batches, where only the manifest is
+	Signer  string `json:",omitempty"`
+	Version string
+}
+
+// Recipient is a receiver key, KeyId is the key or subkey session keys are encrypted to
+type Recipient struct {
+	Fingerprint string
+	KeyId       string
+}
+
+func newRecipient(e *openpgp.Entity) Recipient {
+	recipient := Recipient{Fingerprint: encrypt.Fingerprint(e)}
+	if key, ok := e.EncryptionKey(time.Now()); ok {
+		recipient.KeyId = fmt.Sprintf("%016X", key.PublicKey.KeyId)
+	}
+	return recipient
+}
+
+// NewCrypto describes a batch shared with opts to the receivers, signed by signer when it is not nil
+func NewCrypto(receivers openpgp.EntityList, signer *openpgp.Entity, version string, opts options.Options) *Crypto {
+	format := encrypt.OutputFormat(opts)
+	cipher, hash := encrypt.Algorithms(format)
+	crypto := &Crypto{
+		Cipher:      cipher,
+		Hash:        hash,
+		Compression: opts.Compression,
+		Armored:     format == encrypt.FormatArmored,
+		Version:     version,
+	}
+	switch format {
+	case encrypt.FormatKMS:
+		crypto.KeyId = opts.KmsKeyId
+	case encrypt.FormatTransit:
+		crypto.KeyId = opts.VaultTransitKey
+	}
+	for _, e := range receivers {
+		crypto.Recipients = append(crypto.Recipients, newRecipient(e))
+	}
+	if signer != nil {
+		crypto.Signer = encrypt.Fingerprint(signer)
+	}
+	return crypto
+}
+
+// Rekey replaces the recipients the old keyring can decrypt with the new receivers, as rekey does for every object
+func (c *Crypto) Rekey(keyring openpgp.EntityList, receivers openpgp.EntityList) {
+	var recipients []Recipient
+	for _, r := range c.Recipients {
+		if !canDecrypt(keyring, r) {
+			recipients = append(recipients, r)
+		}
+	}
+	for _, e := range receivers {
+		recipient := newRecipient(e)
+		if !containsRecipient(recipients, recipient) {
+			recipients = append(recipients, recipient)
+		}
+	}
+	c.Recipients = recipients
+}
+
+func containsRecipient(recipients []Recipient, recipient Recipient) bool {
+	for _, r := range recipients {
+		if r == recipient {
+			return true
+		}
+	}
+	return false
+}
+
+// canDecrypt reports whether any of the keyring's decryption keys is the one the recipient's session keys are encrypted to
+func canDecrypt(keyring openpgp.EntityList, r Recipient) bool {
+	for _, key := range keyring.DecryptionKeys() {
+		if fmt.Sprintf("%016X", key.PublicKey.KeyId) == r.KeyId {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckKeyring fails when none of the batch's recipients can be decrypted with keyring
+func (c *Crypto) CheckKeyring(keyring openpgp.EntityList) error {
+	if len(c.Recipients) == 0 {
+		return nil
+	}
+	var fingerprints []string
+	for _, r := range c.Recipients {
+		if canDecrypt(keyring, r) {
+			return nil
+		}
+		fingerprints = append(fingerprints, r.Fingerprint)
+	}
+	return fmt.Errorf("the private key does not match any key the batch is encrypted to (%s)", strings.Join(fingerprints, ", "))
+}
+
+// CheckRecipients fails when keyring can't decrypt the batch stored in backend.
+// A batch re-keyed in place keeps the recipients it was shared to when its signed manifest is left alone, so when the manifest
+// rules keyring out the objects have the final say, stale reports they are encrypted to it after all.
+func (m Manifest) CheckRecipients(backend storage.Backend, keyring openpgp.EntityList) (stale bool, err error) {
+	if m.Crypto == nil {
+		return false, nil
+	}
+	err = m.Crypto.CheckKeyring(keyring)
+	if err == nil {
+		return false, nil
+	}
+
+	var object_keys []string
+	if m.Sealed != nil {
+		object_keys = append(object_keys, storage.ObjectKey(m.Organization, m.Folder, m.Sealed.Name))
+	}
+	for _, fs := range m.Files {
+		object_keys = append(object_keys, storage.ObjectKey(m.Organization, utils.ToPosixPath(fs.GetEncryptedName(m.Folder))))
+	}
+	for _, object_key := range object_keys {
+		key_ids, read_err := objectRecipients(backend, object_key, m.Format)
+		if read_err == io.EOF {
+			// empty objects have no recipients, the next one decides
+			continue
+		}
+		if read_err == nil && encryptedToKeyring(keyring, key_ids) {
+			return true, nil
+		}
+		break
+	}
+	return false, err
+}
+
+// Reads only the session keys at the start of an object
+func objectRecipients(backend storage.Backend, object_key string, format string) ([]uint64, error) {
+	body, err := backend.Get(object_key)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return encrypt.EncryptedTo(body, format)
+}
+
+func encryptedToKeyring(keyring openpgp.EntityList, key_ids []uint64) bool {
+	for _, key := range keyring.DecryptionKeys() {
+		for _, key_id := range key_ids {
+			if key.PublicKey.KeyId == key_id {
+				return true
+			}
+		}
+	}
+	return false
+}
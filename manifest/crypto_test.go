@@ -0,0 +1,75 @@
+package manifest
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	compress "github.com/tempuslabs/s3s2/compress"
+	encrypt "github.com/tempuslabs/s3s2/encrypt"
+	file "github.com/tempuslabs/s3s2/file"
+	options "github.com/tempuslabs/s3s2/options"
+	storage "github.com/tempuslabs/s3s2/storage"
+)
+
+func keyPair(t *testing.T, name string) (openpgp.EntityList, openpgp.EntityList) {
+	dir := t.TempDir()
+	encrypt.GenerateKeys(dir, name, encrypt.AlgoCurve25519, 0, nil)
+	opts := options.Options{PubKey: filepath.Join(dir, name+".pubkey"), PrivKey: filepath.Join(dir, name+".privkey")}
+	return encrypt.GetPubKeys(nil, opts), encrypt.GetPrivKey(nil, opts)
+}
+
+// a batch whose objects were re-keyed in place while its manifest still names the keys it was shared to
+func rekeyedBatch(t *testing.T, shared_to openpgp.EntityList, rekeyed_to openpgp.EntityList) (storage.Backend, Manifest) {
+	opts := options.Options{Bucket: "file://" + filepath.ToSlash(t.TempDir()), Org: "TESTORG"}
+	backend, err := storage.NewBackend(opts)
+	require.NoError(t, err)
+
+	m := Manifest{
+		Organization: "TESTORG",
+		Folder:       "clinical_s3s2_20200101000000_0",
+		Format:       encrypt.FormatArmored,
+		Crypto:       NewCrypto(shared_to, nil, "test", opts),
+		Files:        []file.File{{Name: "a.txt", Compression: compress.Gzip}},
+	}
+
+	var object bytes.Buffer
+	w, err := encrypt.NewEncryptWriter(rekeyed_to, nil, &object, compress.Gzip, opts)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("top level file"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, backend.PutStream(storage.ObjectKey(m.Organization, m.Files[0].GetEncryptedName(m.Folder)), &object))
+	return backend, m
+}
+
+func TestCheckRecipients(t *testing.T) {
+	old_pub, old_priv := keyPair(t, "old")
+	new_pub, new_priv := keyPair(t, "new")
+	_, other_priv := keyPair(t, "other")
+	backend, m := rekeyedBatch(t, old_pub, new_pub)
+
+	stale, err := m.CheckRecipients(backend, old_priv)
+	assert.NoError(t, err)
+	assert.False(t, stale)
+
+	// the manifest rules the new key out, the object lets it in
+	stale, err = m.CheckRecipients(backend, new_priv)
+	assert.NoError(t, err)
+	assert.True(t, stale)
+
+	_, err = m.CheckRecipients(backend, other_priv)
+	assert.ErrorContains(t, err, "does not match any key the batch is encrypted to")
+}
+
+// manifests shared before the crypto was recorded are not checked up front
+func TestCheckRecipientsWithoutCrypto(t *testing.T) {
+	_, priv := keyPair(t, "receiver")
+	stale, err := Manifest{}.CheckRecipients(nil, priv)
+	assert.NoError(t, err)
+	assert.False(t, stale)
+}
@@ -11,14 +11,20 @@ import (
     "github.com/json-iterator/go"
 	log "github.com/sirupsen/logrus"
 
+	encrypt "github.com/tempuslabs/s3s2/encrypt"
 	file "github.com/tempuslabs/s3s2/file"
 	utils "github.com/tempuslabs/s3s2/utils"
 	options "github.com/tempuslabs/s3s2/options"
 
 )
 
+// Suffix of the detached signature uploaded next to a signed manifest
+const SignatureSuffix = ".sig"
+
 // Manifest is a description of files.
 type Manifest struct {
+	// version of the format the manifest is written in, 0 for version 1 manifests read without upgrading
+	SchemaVersion int
 	Name         string
 	Timestamp    time.Time
 	Organization string
@@ -26,34 +32,44 @@ type Manifest struct {
 	User         string
 	SudoUser     string
 	Folder       string
+	// how the objects are encoded, empty for batches shared before binary output existed
+	Format       string `json:",omitempty"`
+	// how the objects are encrypted, nil for batches shared before it was recorded
+	Crypto       *Crypto `json:",omitempty"`
+	// set on the stub of a batch shared with opaque object names, its files are only listed in the encrypted manifest
+	Sealed       *Sealed `json:",omitempty"`
 	Files        []file.File
 }
 
 
-// ReadManifest from a file.
+// ReadManifest from a file, upgraded to the current schema version.
 func ReadManifest(file string) Manifest {
-	var m Manifest
-
 	rfile, err := os.Open(file)
 	utils.PanicIfError("Error opening manifest - ", err)
 
 	bytes, err := ioutil.ReadAll(rfile)
     utils.PanicIfError("Error reading manifest - ", err)
 
-	jsoniter.Unmarshal(bytes, &m)
-
     defer rfile.Close()
 
+	m, err := ParseManifest(bytes)
+	utils.PanicIfError("Error reading manifest - ", err)
+
 	return m
 }
 
-func BuildManifest(file_structs []file.File, batch_folder string, options options.Options) (Manifest, error) {
+func BuildManifest(file_structs []file.File, batch_folder string, crypto *Crypto, options options.Options) (Manifest, error) {
 
     log.Debug("Building manifest...")
 
 	user, err := user.Current()
 	sudoUser := os.Getenv("SUDO_USER") // In case they are sudo'ing, we can know the acting user.
+	schema_version := SchemaVersion
+	if options.OpaqueNames {
+		schema_version = SealedSchemaVersion
+	}
 	manifest := Manifest{
+		SchemaVersion: schema_version,
 		Name:         filepath.Clean("s3s2_manifest.json"),
 		Timestamp:    time.Now(),
 		Organization: options.Org,
@@ -61,18 +77,28 @@ func BuildManifest(file_structs []file.File, batch_folder string, options option
 		User:         user.Username,
 		SudoUser:     sudoUser,
 		Folder:       batch_folder,
+		Format:       encrypt.OutputFormat(options),
+		Crypto:       crypto,
 		Files:        file_structs,
 	}
 
-	err = writeManifest(manifest, options.Directory)
-	utils.PanicIfError("Error getting current user - ", err)
+	// streaming shares may read from a read-only mount, the manifest is uploaded from memory instead
+	if !options.Streaming {
+		err = writeManifest(manifest, options.Directory)
+		utils.PanicIfError("Error getting current user - ", err)
+	}
 
 	return manifest, err
 }
 
 
+// Marshal renders the manifest as it is stored alongside the batch
+func (m Manifest) Marshal() ([]byte, error) {
+	return jsoniter.MarshalIndent(m, "", " ")
+}
+
 func writeManifest(manifest Manifest, directory string) error {
-	file, err := jsoniter.MarshalIndent(manifest, "", " ")
+	file, err := manifest.Marshal()
 	filename := filepath.Join(directory, "s3s2_manifest.json")
 
 	log.Debugf("Creating local manifest '%s'", filename)
@@ -0,0 +1,76 @@
+package manifest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	log "github.com/sirupsen/logrus"
+
+	file "github.com/tempuslabs/s3s2/file"
+)
+
+// Failure is a file whose decrypted copy does not match what share recorded in the manifest
+type Failure struct {
+	Name   string
+	Size   int64
+	Sha256 string
+	Error  string
+}
+
+// Report accounts for every file of a manifest decrypt verified, so integrity can be proven end to end
+type Report struct {
+	Manifest  string
+	Folder    string
+	Timestamp time.Time
+	Verified  int
+	// files from manifests written before share recorded checksums
+	Unverified int
+	Failures   []Failure
+
+	mu sync.Mutex
+}
+
+func NewReport(manifest_key string, m Manifest) *Report {
+	return &Report{Manifest: manifest_key, Folder: m.Folder, Failures: []Failure{}}
+}
+
+// Check verifies the decrypted copy of fs at path and records the outcome
+func (r *Report) Check(fs file.File, path string) error {
+	err := fs.Verify(path)
+	r.Record(fs, err)
+	return err
+}
+
+// Record adds the outcome of verifying fs to the report
+func (r *Report) Record(fs file.File, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case err != nil:
+		log.Errorf("File '%s' failed verification - %s", fs.Name, err)
+		r.Failures = append(r.Failures, Failure{Name: fs.Name, Size: fs.Size, Sha256: fs.Sha256, Error: err.Error()})
+	case fs.Stamped():
+		r.Verified += 1
+	default:
+		r.Unverified += 1
+	}
+}
+
+// Write saves the report as s3s2_integrity_<folder>.json in directory, returning its path
+func (r *Report) Write(directory string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Timestamp = time.Now()
+
+	data, err := jsoniter.MarshalIndent(r, "", " ")
+	if err != nil {
+		return "", err
+	}
+	filename := filepath.Join(directory, fmt.Sprintf("s3s2_integrity_%s.json", r.Folder))
+	log.Debugf("Writing integrity report '%s'", filename)
+	return filename, ioutil.WriteFile(filename, data, 0644)
+}
@@ -0,0 +1,152 @@
+package manifest
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+
+	compress "github.com/tempuslabs/s3s2/compress"
+	encrypt "github.com/tempuslabs/s3s2/encrypt"
+	utils "github.com/tempuslabs/s3s2/utils"
+)
+
+// SchemaVersion is the version of the manifest format share writes, as described by s3s2_manifest.schema.json.
+// Manifests without one are version 1, written before it was recorded, and are upgraded when read.
+// Fields may be added within a version, readers ignore the ones they don't know, anything older readers can't ignore is a new version.
+const SchemaVersion = 2
+
+// SealedSchemaVersion is written instead for batches shared with opaque object names, which older readers cannot decrypt.
+// Only those batches carry it, so every other batch stays readable by them.
+const SealedSchemaVersion = 3
+
+var (
+	fingerprintPattern = regexp.MustCompile(`^[0-9A-F]+$`)
+	// object names are joined onto local directories, they may not be paths
+	objectNamePattern = regexp.MustCompile(`^[0-9A-Za-z_-]+$`)
+)
+
+// ParseManifest reads a manifest of any supported schema version, upgrading it to the current one, and validates it
+func ParseManifest(data []byte) (Manifest, error) {
+	var m Manifest
+	if err := jsoniter.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("invalid manifest - %s", err)
+	}
+	if m.SchemaVersion > SealedSchemaVersion {
+		return m, fmt.Errorf("manifest schema version %d is newer than the %d this s3s2 supports, upgrade s3s2", m.SchemaVersion, SealedSchemaVersion)
+	}
+	if m.SchemaVersion < SchemaVersion {
+		m.upgradeV1()
+	}
+	if err := m.Validate(); err != nil {
+		return m, fmt.Errorf("invalid manifest - %s", err)
+	}
+	return m, nil
+}
+
+// upgradeV1 fills in what version 1 manifests left implicit, they may list nothing but the name of each file
+func (m *Manifest) upgradeV1() {
+	m.SchemaVersion = 2
+	if m.Name == "" {
+		m.Name = "s3s2_manifest.json"
+	}
+	for i := range m.Files {
+		if m.Files[i].Name != "" {
+			m.Files[i].Name = utils.ToPosixPath(m.Files[i].Name)
+		}
+		if m.Files[i].Compression == "" {
+			m.Files[i].Compression = compress.Codec("")
+		}
+	}
+}
+
+// Validate checks the manifest against the current schema, reporting every problem found.
+// Like the published schema it accepts fields it doesn't know, they were dropped when the manifest was parsed.
+func (m Manifest) Validate() error {
+	var problems []string
+	fail := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if m.SchemaVersion != SchemaVersion && m.SchemaVersion != SealedSchemaVersion {
+		fail("schema version %d, expected %d or %d", m.SchemaVersion, SchemaVersion, SealedSchemaVersion)
+	}
+	// readers of the older version would look for the objects under their file names
+	opaque := m.SchemaVersion == SealedSchemaVersion
+	if m.Name == "" {
+		fail("no name")
+	}
+	if m.Folder == "" {
+		fail("no batch folder")
+	}
+	if m.Organization == "" {
+		fail("no organization")
+	}
+	// without a format decrypt detects how each object is encoded
+	switch m.Format {
+	case "", encrypt.FormatArmored, encrypt.FormatBinary, encrypt.FormatKMS, encrypt.FormatTransit:
+	default:
+		fail("unknown format '%s'", m.Format)
+	}
+
+	for i, f := range m.Files {
+		// decrypt writes every file below its directory, names must not climb out of it
+		name := path.Clean(utils.ToPosixPath(f.Name))
+		if f.Name == "" || name == "." {
+			fail("file %d has no name", i)
+		} else if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			fail("file '%s' is not relative to the batch folder", f.Name)
+		}
+		if f.Size < 0 {
+			fail("file '%s' has a negative size", f.Name)
+		}
+		if f.Compression != "" && !compress.Valid(f.Compression) {
+			fail("file '%s' has an unknown compression '%s'", f.Name, f.Compression)
+		}
+		if encrypt.Enveloped(m.Format) && f.DataKey == "" {
+			fail("file '%s' has no data key", f.Name)
+		}
+		if f.Key != "" && !objectNamePattern.MatchString(f.Key) {
+			fail("file '%s' has an invalid key '%s'", f.Name, f.Key)
+		}
+		if f.Key != "" && !opaque {
+			fail("file '%s' has a key, which needs schema version %d", f.Name, SealedSchemaVersion)
+		}
+	}
+
+	if m.Sealed != nil {
+		if !opaque {
+			fail("the manifest is sealed, which needs schema version %d", SealedSchemaVersion)
+		}
+		if len(m.Files) > 0 {
+			fail("the stub of a sealed manifest lists files")
+		}
+		if name := m.Sealed.Name; name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			fail("invalid sealed manifest name '%s'", m.Sealed.Name)
+		}
+		if encrypt.Enveloped(m.Format) && m.Sealed.DataKey == "" {
+			fail("the sealed manifest has no data key")
+		}
+		if !encrypt.Enveloped(m.Format) && m.Sealed.DataKey != "" {
+			fail("the sealed manifest has a data key, but the batch is not sealed under data keys")
+		}
+	}
+
+	if m.Crypto != nil {
+		if m.Crypto.Cipher == "" {
+			fail("crypto has no cipher")
+		}
+		for _, r := range m.Crypto.Recipients {
+			if !fingerprintPattern.MatchString(r.Fingerprint) {
+				fail("crypto has a recipient with an invalid fingerprint '%s'", r.Fingerprint)
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
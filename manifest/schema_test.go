@@ -0,0 +1,150 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	compress "github.com/tempuslabs/s3s2/compress"
+	file "github.com/tempuslabs/s3s2/file"
+	options "github.com/tempuslabs/s3s2/options"
+)
+
+func compileSchema(t *testing.T) *jsonschema.Schema {
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat = true
+	schema, err := compiler.Compile("s3s2_manifest.schema.json")
+	require.NoError(t, err)
+	return schema
+}
+
+func validateSchema(t *testing.T, schema *jsonschema.Schema, data []byte) error {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	require.NoError(t, decoder.Decode(&doc))
+	return schema.Validate(doc)
+}
+
+func stampedFiles(data_keys bool, keys bool) []file.File {
+	files := []file.File{
+		{Name: "a.txt", Size: 14, ModTime: time.Now(), Sha256: "ec86b793541755ff08c98a1cf62da484a34b665feda9759f511dc86d4c3e30cc", Compression: compress.Gzip},
+		{Name: "nested/b.txt", Size: 11, ModTime: time.Now(), Compression: compress.Zstd},
+		{Name: "image.dcm", Size: 0, ModTime: time.Now(), Compression: compress.None},
+	}
+	for i := range files {
+		if data_keys {
+			files[i].DataKey = "d3JhcHBlZA=="
+		}
+		if keys {
+			files[i].NewKey()
+		}
+	}
+	return files
+}
+
+// every variant share writes passes both Validate and the published schema, so the two can't drift apart
+func TestBuiltManifestsMatchSchema(t *testing.T) {
+	schema := compileSchema(t)
+	pub, _ := keyPair(t, "receiver")
+	_, signer := keyPair(t, "sender")
+	kms := options.Options{KmsKeyId: "alias/s3s2"}
+	transit := options.Options{VaultTransitKey: "s3s2"}
+
+	manifests := map[string]Manifest{}
+	for name, variant := range map[string]struct {
+		opts      options.Options
+		crypto    bool
+		data_keys bool
+	}{
+		"armored":         {options.Options{}, false, false},
+		"armored crypto":  {options.Options{}, true, false},
+		"binary crypto":   {options.Options{Binary: true}, true, false},
+		"opaque":          {options.Options{OpaqueNames: true}, true, false},
+		"kms":             {kms, true, true},
+		"kms opaque":      {options.Options{KmsKeyId: "alias/s3s2", OpaqueNames: true}, true, true},
+		"transit":         {transit, true, true},
+		"transit opaque":  {options.Options{VaultTransitKey: "s3s2", OpaqueNames: true}, false, true},
+		"binary no crypt": {options.Options{Binary: true, Compression: compress.Zstd}, false, false},
+	} {
+		opts := variant.opts
+		opts.Org = "TESTORG"
+		opts.Streaming = true
+
+		var crypto *Crypto
+		if variant.crypto && variant.data_keys {
+			crypto = NewCrypto(nil, signer[0], "test", opts)
+		} else if variant.crypto {
+			crypto = NewCrypto(pub, signer[0], "test", opts)
+		}
+		m, err := BuildManifest(stampedFiles(variant.data_keys, opts.OpaqueNames), "clinical_s3s2_20200101000000_0", crypto, opts)
+		require.NoError(t, err)
+		manifests[name] = m
+
+		if opts.OpaqueNames {
+			sealed := Sealed{Name: m.Name + SealedSuffix}
+			if variant.data_keys {
+				sealed.DataKey = "d3JhcHBlZA=="
+			}
+			manifests[name+" stub"] = m.Stub(sealed)
+		}
+	}
+	// version 1 manifests are upgraded as they are read
+	v1, err := ParseManifest([]byte(`{"Organization": "TESTORG", "Folder": "f", "Files": [{"Name": "a.txt"}]}`))
+	require.NoError(t, err)
+	manifests["upgraded v1"] = v1
+
+	for name, m := range manifests {
+		t.Run(name, func(t *testing.T) {
+			data, err := m.Marshal()
+			require.NoError(t, err)
+			_, err = ParseManifest(data)
+			assert.NoError(t, err)
+			assert.NoError(t, validateSchema(t, schema, data))
+		})
+	}
+}
+
+// what Validate refuses the schema refuses too
+func TestInvalidManifestsFailSchema(t *testing.T) {
+	schema := compileSchema(t)
+	for name, manifest := range map[string]string{
+		"unknown version":     `{"SchemaVersion": 4, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Files": []}`,
+		"unknown format":      `{"SchemaVersion": 2, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Format": "rot13", "Files": []}`,
+		"no organization":     `{"SchemaVersion": 2, "Name": "s3s2_manifest.json", "Organization": "", "Folder": "f", "Files": []}`,
+		"climbs out":          `{"SchemaVersion": 2, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Files": [{"Name": "../../.bashrc"}]}`,
+		"absolute":            `{"SchemaVersion": 2, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Files": [{"Name": "/etc/cron.d/job"}]}`,
+		"negative size":       `{"SchemaVersion": 2, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Files": [{"Name": "a.txt", "Size": -1}]}`,
+		"unknown codec":       `{"SchemaVersion": 2, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Files": [{"Name": "a.txt", "Compression": "lzma"}]}`,
+		"key is a path":       `{"SchemaVersion": 3, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Files": [{"Name": "a.txt", "Key": "../a"}]}`,
+		"v2 key":              `{"SchemaVersion": 2, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Files": [{"Name": "a.txt", "Key": "0a1b"}]}`,
+		"v2 sealed":           `{"SchemaVersion": 2, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Sealed": {"Name": "s3s2_manifest.json.gpg"}, "Files": null}`,
+		"stub lists files":    `{"SchemaVersion": 3, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Sealed": {"Name": "s3s2_manifest.json.gpg"}, "Files": [{"Name": "a.txt"}]}`,
+		"sealed name path":    `{"SchemaVersion": 3, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Sealed": {"Name": "../s3s2_manifest.json.gpg"}, "Files": null}`,
+		"no data key":         `{"SchemaVersion": 2, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Format": "kms", "Files": [{"Name": "a.txt"}]}`,
+		"sealed no key":       `{"SchemaVersion": 3, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Format": "vault-transit", "Sealed": {"Name": "s3s2_manifest.json.gpg"}, "Files": null}`,
+		"stray data key":      `{"SchemaVersion": 3, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Format": "armored", "Sealed": {"Name": "s3s2_manifest.json.gpg", "DataKey": "d3JhcHBlZA=="}, "Files": null}`,
+		"no cipher":           `{"SchemaVersion": 2, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Crypto": {"Cipher": ""}, "Files": []}`,
+		"lowercase recipient": `{"SchemaVersion": 2, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Crypto": {"Cipher": "AES256", "Recipients": [{"Fingerprint": "abc"}]}, "Files": []}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseManifest([]byte(manifest))
+			assert.Error(t, err)
+			assert.Error(t, validateSchema(t, schema, []byte(manifest)))
+		})
+	}
+}
+
+// fields readers don't know are accepted by both, they may be added within a version
+func TestUnknownFieldsAreAccepted(t *testing.T) {
+	manifest := []byte(`{"SchemaVersion": 2, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Retention": "P7Y",
+		"Crypto": {"Cipher": "AES256", "Quantum": true}, "Files": [{"Name": "a.txt", "Owner": "lab"}]}`)
+	_, err := ParseManifest(manifest)
+	assert.NoError(t, err)
+	assert.NoError(t, validateSchema(t, compileSchema(t), manifest))
+}
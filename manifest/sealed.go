@@ -0,0 +1,43 @@
+package manifest
+
+import (
+	"fmt"
+	"io"
+)
+
+// Suffix of the encrypted manifest uploaded next to the stub of a batch shared with opaque object names
+const SealedSuffix = ".gpg"
+
+// Sealed points a stub manifest to the encrypted manifest that lists the batch's files
+type Sealed struct {
+	// object name of the encrypted manifest within the batch folder
+	Name string
+	// wrapped data key the encrypted manifest is sealed with, only for envelope batches
+	DataKey string `json:",omitempty"`
+}
+
+// Stub returns the manifest without its files, to be uploaded in the clear next to the encrypted manifest sealed describes
+func (m Manifest) Stub(sealed Sealed) Manifest {
+	m.SchemaVersion = SealedSchemaVersion
+	m.Sealed = &sealed
+	m.Files = nil
+	return m
+}
+
+// Unseal returns the stub with the files listed by the encrypted manifest it points to, read decrypted from plain.
+// Everything else comes from the stub, which is what rekey updates and share signs.
+func (m Manifest) Unseal(plain io.Reader) (Manifest, error) {
+	data, err := io.ReadAll(plain)
+	if err != nil {
+		return m, err
+	}
+	sealed, err := ParseManifest(data)
+	if err != nil {
+		return m, err
+	}
+	if sealed.Organization != m.Organization || sealed.Sealed != nil {
+		return m, fmt.Errorf("the encrypted manifest '%s' does not belong to the batch in '%s'", m.Sealed.Name, m.Folder)
+	}
+	m.Files = sealed.Files
+	return m, nil
+}
@@ -1,5 +1,7 @@
 package options
 
+import "time"
+
 // Options is the information we need about a particular sharing activity.
 type Options struct {
 	// For both encrypt/decrypt
@@ -10,14 +12,47 @@ type Options struct {
 	Org         string `json:"org"`
 	Parallelism int    `json:"parallelism"`
 	AwsRoleArn	string `json:"aws-role-arn"`
+	Streaming   bool   `json:"streaming"`
+	MemoryLimit int    `json:"memory-limit"`
+	EndpointUrl string `json:"endpoint-url"`
+	PathStyle   bool   `json:"path-style"`
+	CaBundle    string `json:"ca-bundle"`
+	KmsEndpointUrl string `json:"kms-endpoint-url"`
+	VaultAddr      string `json:"vault-addr"`
+	VaultTransitKey string `json:"vault-transit-key"`
+
+	// Azure destinations only
+	AzureAccount          string `json:"azure-account"`
+	AzureAccountKey       string `json:"azure-account-key"`
+	AzureSasToken         string `json:"azure-sas-token"`
+	AzureConnectionString string `json:"azure-connection-string"`
+
+	// SFTP destinations only
+	SftpKey        string `json:"sftp-key"`
+	SftpKnownHosts string `json:"sftp-known-hosts"`
+
+	// Passphrase of a protected private key, prompted for when none of these are set
+	PassphraseEnv string `json:"passphrase-env"`
+	PassphraseFd  string `json:"passphrase-fd"`
+	SSMPassphrase string `json:"ssm-passphrase"`
 
 	// Encrypt only
 	PubKey             string   `json:"pubkey"`
 	SSMPubKey          string   `json:"ssmpubkey"`
+	ReceiverKeyFingerprint string `json:"receiver-key-fingerprint"`
+	KeyCacheTtl        time.Duration `json:"key-cache-ttl"`
+	KeyCacheMaxStale   time.Duration `json:"key-cache-max-stale"`
 	IsGCS          	   bool   	`json:"isgcs"`
 	AwsKey             string   `json:"awskey"`
 	Prefix             string   `json:"prefix"`
 	Hash               bool     `json:"hash"`
+	Binary             bool     `json:"binary"`
+	KmsKeyId           string   `json:"kms-key-id"`
+	OpaqueNames        bool     `json:"opaque-names"`
+	Compression        string   `json:"compression"`
+	CompressionLevel   int      `json:"compression-level"`
+	NoCompressExtensions string `json:"no-compress-extensions"`
+	NoCompressTypes    string   `json:"no-compress-types"`
 	ArchiveDirectory   string   `json:"archive-directory"`
 	ScratchDirectory   string   `json:"scratch-directory"`
 	MetaDataFiles      []string `json:"metadata-files"`
@@ -26,10 +61,19 @@ type Options struct {
 	LambdaTrigger      bool     `json:"lambda-trigger"`
 	DeleteOnCompletion bool     `json:"delete-on-completion"`
 	ShareFromList      string   `json:"share-from-list"`
+	SignKey            string   `json:"sign-key"`
+	SSMSignKey         string   `json:"ssm-sign-key"`
+	Resume             string   `json:"resume"`
 
 	// Decrypt only
 	File        string `json:"file"`
 	PrivKey     string `json:"privkey"`
 	SSMPrivKey  string `json:"ssmprivkey"`
 	FilterFiles string `json:"fileterFiles"`
+	SkipExisting bool  `json:"skip-existing"`
+	TrustedSenders string `json:"trusted-senders"`
+	Strict      bool   `json:"strict"`
+
+	// Rekey only
+	BatchFolder string `json:"batch-folder"`
 }
@@ -9,18 +9,17 @@ import (
 	"sync"
 	"time"
 
-	"golang.org/x/crypto/openpgp/packet"
-
-	session "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/ProtonMail/go-crypto/openpgp"
 
 	log "github.com/sirupsen/logrus"
 
 	// local
-	aws_helpers "github.com/tempuslabs/s3s2/aws_helpers"
+	compress "github.com/tempuslabs/s3s2/compress"
 	encrypt "github.com/tempuslabs/s3s2/encrypt"
 	file "github.com/tempuslabs/s3s2/file"
 	manifest "github.com/tempuslabs/s3s2/manifest"
 	options "github.com/tempuslabs/s3s2/options"
+	storage "github.com/tempuslabs/s3s2/storage"
 	utils "github.com/tempuslabs/s3s2/utils"
 	wc_helpers "github.com/tempuslabs/s3s2/wc_helpers"
 	zip "github.com/tempuslabs/s3s2/zip"
@@ -64,8 +63,10 @@ func Decrypt(
 
 	// top level clients
 	sess := utils.GetAwsSession(opts)
-	_pubKey := encrypt.GetPubKey(sess, opts)
-	_privKey := encrypt.GetPrivKey(sess, opts)
+	_keyring := encrypt.GetPrivKey(sess, opts)
+
+	backend, err := storage.NewBackend(opts)
+	utils.PanicIfError("Unable to create storage backend - ", err)
 
 	os.MkdirAll(opts.Directory, os.ModePerm)
 
@@ -75,10 +76,23 @@ func Decrypt(
 		log.Info("Detected manifest file...")
 
 		target_manifest_path := filepath.Join(opts.Directory, filepath.Base(opts.File))
-		fn, err := aws_helpers.DownloadFile(sess, opts.Bucket, opts.Org, opts.File, target_manifest_path, opts)
+		fn, err := storage.DownloadFile(backend, storage.ObjectKey(opts.Org, opts.File), target_manifest_path)
 		utils.PanicIfError("Unable to download file at strings.HasSuffix - ", err)
 
 		m := manifest.ReadManifest(fn)
+		if encrypt.Enveloped(m.Format) {
+			log.Panic("Batches shared with envelope encryption are not supported here, use s3s2 decrypt.")
+		}
+		stale, err := m.CheckRecipients(backend, _keyring)
+		if err != nil {
+			log.Panicf("Refusing batch, %s.", err)
+		}
+		if stale {
+			log.Warn("The manifest's recipients are out of date, the batch was re-keyed after it was shared")
+		}
+		if m.Sealed != nil {
+			m = openSealedManifest(backend, _keyring, m, opts)
+		}
 		batch_folder := m.Folder
 		file_structs := m.Files
 
@@ -104,16 +118,14 @@ func Decrypt(
 		sem := make(chan int, opts.Parallelism)
 		for _, fs := range file_structs {
 			wg.Add(1)
-			go func(wg *sync.WaitGroup, sess *session.Session, _pubkey *packet.PublicKey, _privKey *packet.PrivateKey, folder string, fs file.File, opts options.Options) {
+			go func(wg *sync.WaitGroup, backend storage.Backend, _keyring openpgp.EntityList, folder string, fs file.File, opts options.Options) {
 				sem <- 1
 				defer func() { <-sem }()
 				defer wg.Done()
-				// if block is for cases where AWS session expires, so we re-create session and attempt file again
-				// RefreshSession logic is not needed here since stale sessions are handled in the if block
-				err, skipped := decryptFile(sess, _pubKey, _privKey, m, fs, opts)
+				// if block is for cases where AWS session expires, backends fetch a fresh session per call so we attempt file again
+				err, skipped := decryptFile(backend, _keyring, m, fs, opts)
 				if err != nil || skipped {
-					sess = utils.GetAwsSession(opts)
-					err, skipped := decryptFile(sess, _pubKey, _privKey, m, fs, opts)
+					err, skipped := decryptFile(backend, _keyring, m, fs, opts)
 					if err != nil {
 						log.Warn("Error during decrypt-file session expiration if block!")
 						log.Errorf("Error: '%v'", err)
@@ -142,14 +154,33 @@ func Decrypt(
 						f.Close()
 					}
 				}
-			}(&wg, sess, _pubKey, _privKey, batch_folder, fs, opts)
+			}(&wg, backend, _keyring, batch_folder, fs, opts)
 		}
 		wg.Wait()
 	}
 	return 1
 }
 
-func decryptFile(sess *session.Session, _pubkey *packet.PublicKey, _privkey *packet.PrivateKey, m manifest.Manifest, fs file.File, opts options.Options) (error, bool) {
+// Batches shared with opaque object names only list their files in the encrypted manifest the stub points to
+func openSealedManifest(backend storage.Backend, _keyring openpgp.EntityList, m manifest.Manifest, opts options.Options) manifest.Manifest {
+	// only envelope batches seal their manifest under a data key, and there are no data keys to unwrap it with here
+	if m.Sealed.DataKey != "" {
+		log.Panic("The manifest of this batch is sealed under a data key, which is not supported here, use s3s2 decrypt.")
+	}
+	body, err := backend.Get(storage.ObjectKey(m.Organization, m.Folder, m.Sealed.Name))
+	utils.PanicIfError("Unable to download encrypted manifest - ", err)
+	defer body.Close()
+
+	plain, err := encrypt.NewDecryptReader(_keyring, nil, body, m.Format, compress.Gzip, opts)
+	utils.PanicIfError("Unable to decrypt manifest - ", err)
+	defer plain.Close()
+
+	m, err = m.Unseal(plain)
+	utils.PanicIfError("Error reading encrypted manifest - ", err)
+	return m
+}
+
+func decryptFile(backend storage.Backend, _keyring openpgp.EntityList, m manifest.Manifest, fs file.File, opts options.Options) (error, bool) {
 	start := time.Now()
 	skipped := false
 	log.Debugf("Starting decryption on file '%s'", fs.Name)
@@ -163,10 +194,11 @@ func decryptFile(sess *session.Session, _pubkey *packet.PublicKey, _privkey *pac
 	fn_zip := fs.GetZipName(opts.Directory)
 	fn_decrypt := fs.GetSourceName("decrypted")
 
-	nested_dir := filepath.Dir(target_path)
+	// the zip is written next to where the file ends up, the object may be stored under an opaque name
+	nested_dir := filepath.Dir(fn_zip)
 	os.MkdirAll(nested_dir, os.ModePerm)
 
-	_, err := aws_helpers.DownloadFile(sess, opts.Bucket, m.Organization, aws_key, target_path, opts)
+	_, err := storage.DownloadFile(backend, storage.ObjectKey(m.Organization, aws_key), target_path)
 	utils.PanicIfError("Main download failed - ", err)
 
 	// Check if downloaded file is empty
@@ -179,7 +211,10 @@ func decryptFile(sess *session.Session, _pubkey *packet.PublicKey, _privkey *pac
 		log.Warningf("Downloaded file '%s' is empty", target_path)
 		skipped = true
 	} else {
-		encrypt.DecryptFile(_pubkey, _privkey, target_path, fn_zip, opts)
+		err = encrypt.DecryptFile(_keyring, nil, target_path, fn_zip, m.Format, fs.Compression, opts)
+		if err != nil {
+			return err, skipped
+		}
 		zip.UnZipFile(fn_zip, fn_decrypt, opts.Directory)
 
 		utils.Timing(start, fmt.Sprintf("\tProcessed file '%s' in ", fs.Name)+"%f seconds")
@@ -201,9 +236,6 @@ func checkDecryptOptions(options options.Options) {
 	} else if options.Region == "" {
 		log.Warn("Need to supply a region for the S3 bucket.")
 		log.Panic("Insufficient information to perform decryption.")
-	} else if options.PubKey == "" && options.SSMPubKey == "" {
-		log.Warn("Need to supply a public encryption key parameter.")
-		log.Panic("Insufficient information to perform decryption.")
 	} else if options.PrivKey == "" && options.SSMPrivKey == "" {
 		log.Warn("Need to supply a private encryption key parameter.")
 		log.Panic("Insufficient information to perform decryption.")
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/avast/retry-go/v4"
+	log "github.com/sirupsen/logrus"
+	options "github.com/tempuslabs/s3s2/options"
+)
+
+func init() {
+	Register("az", newAzureBackend)
+}
+
+// azureBackend stores objects as blobs in an Azure Storage container, az://container/prefix
+type azureBackend struct {
+	container string
+	prefix    string
+	client    *azblob.Client
+}
+
+// Falls back to the environment variables used by the az cli when an option is not provided
+func azureSetting(value string, env string) string {
+	if value != "" {
+		return value
+	}
+	return os.Getenv(env)
+}
+
+// Builds a client from, in order of preference, a connection string, a shared key or a SAS token
+func newAzureClient(opts options.Options) (*azblob.Client, error) {
+	connection_string := azureSetting(opts.AzureConnectionString, "AZURE_STORAGE_CONNECTION_STRING")
+	account := azureSetting(opts.AzureAccount, "AZURE_STORAGE_ACCOUNT")
+	account_key := azureSetting(opts.AzureAccountKey, "AZURE_STORAGE_KEY")
+	sas_token := strings.TrimPrefix(azureSetting(opts.AzureSasToken, "AZURE_STORAGE_SAS_TOKEN"), "?")
+
+	if connection_string != "" {
+		log.Debug("Authenticating to Azure with a connection string")
+		return azblob.NewClientFromConnectionString(connection_string, nil)
+	}
+
+	if account == "" {
+		return nil, fmt.Errorf("an Azure connection string or storage account name must be provided")
+	}
+	service_url := fmt.Sprintf("https://%s.blob.core.windows.net/", account)
+
+	if account_key != "" {
+		log.Debugf("Authenticating to Azure account '%s' with a shared key", account)
+		cred, err := azblob.NewSharedKeyCredential(account, account_key)
+		if err != nil {
+			return nil, err
+		}
+		return azblob.NewClientWithSharedKeyCredential(service_url, cred, nil)
+	}
+
+	if sas_token != "" {
+		log.Debugf("Authenticating to Azure account '%s' with a SAS token", account)
+		return azblob.NewClientWithNoCredential(service_url+"?"+sas_token, nil)
+	}
+
+	return nil, fmt.Errorf("no Azure credentials provided - need a connection string, shared key or SAS token")
+}
+
+func newAzureBackend(u *url.URL, opts options.Options) (Backend, error) {
+	client, err := newAzureClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &azureBackend{container: u.Host, prefix: u.Path, client: client}, nil
+}
+
+// Given file, open contents and send to Azure
+func (b *azureBackend) Put(key string, local_path string) error {
+	log.Debugf("Uploading file '%s' to azure blob '%s'", local_path, key)
+
+	return retry.Do(
+		func() error {
+			file, err := os.Open(local_path)
+			if err != nil {
+				return retry.Unrecoverable(err)
+			}
+			defer file.Close()
+
+			_, err = b.client.UploadFile(context.Background(), b.container, joinKey(b.prefix, key), file, nil)
+			if err != nil {
+				log.Infof("Retrying to upload file %s", key)
+			}
+			return err
+		},
+		retry.Attempts(5),
+	)
+}
+
+func (b *azureBackend) PutStream(key string, body io.Reader) error {
+	log.Debugf("Streaming upload to azure blob '%s'", key)
+	_, err := b.client.UploadStream(context.Background(), b.container, joinKey(b.prefix, key), body, nil)
+	return err
+}
+
+func (b *azureBackend) Get(key string) (io.ReadCloser, error) {
+	resp, err := b.client.DownloadStream(context.Background(), b.container, joinKey(b.prefix, key), nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (b *azureBackend) Head(key string) (ObjectInfo, error) {
+	blob := b.client.ServiceClient().NewContainerClient(b.container).NewBlobClient(joinKey(b.prefix, key))
+	props, err := blob.GetProperties(context.Background(), nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info := ObjectInfo{Key: key}
+	if props.ContentLength != nil {
+		info.Size = *props.ContentLength
+	}
+	if props.LastModified != nil {
+		info.LastModified = *props.LastModified
+	}
+	return info, nil
+}
+
+func (b *azureBackend) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	root := joinKey(b.prefix, "")
+	full_prefix := joinKey(b.prefix, prefix)
+
+	pager := b.client.NewListBlobsFlatPager(b.container, &azblob.ListBlobsFlatOptions{Prefix: &full_prefix})
+	for pager.More() {
+		page, err := pager.NextPage(context.Background())
+		if err != nil {
+			return objects, err
+		}
+		for _, item := range page.Segment.BlobItems {
+			info := ObjectInfo{Key: strings.TrimPrefix(*item.Name, root)}
+			if item.Properties != nil && item.Properties.ContentLength != nil {
+				info.Size = *item.Properties.ContentLength
+			}
+			if item.Properties != nil && item.Properties.LastModified != nil {
+				info.LastModified = *item.Properties.LastModified
+			}
+			objects = append(objects, info)
+		}
+	}
+	return objects, nil
+}
+
+func (b *azureBackend) Delete(key string) error {
+	_, err := b.client.DeleteBlob(context.Background(), b.container, joinKey(b.prefix, key), nil)
+	return err
+}
@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	options "github.com/tempuslabs/s3s2/options"
+)
+
+// the well known development account every Azurite instance accepts
+const azuriteConnectionString = "DefaultEndpointsProtocol=http;AccountName=devstoreaccount1;" +
+	"AccountKey=Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw==;" +
+	"BlobEndpoint=http://127.0.0.1:10000/devstoreaccount1;"
+
+// an azure backend writing beneath a prefix of a fresh container, skipping the test when Azurite isn't running.
+// AZURITE_CONNECTION_STRING points the tests at an instance other than the default 127.0.0.1:10000
+func azuriteBackend(t *testing.T) *azureBackend {
+	connection_string := os.Getenv("AZURITE_CONNECTION_STRING")
+	address := "127.0.0.1:10000"
+	if connection_string == "" {
+		connection_string = azuriteConnectionString
+	} else {
+		for _, setting := range strings.Split(connection_string, ";") {
+			if endpoint, ok := strings.CutPrefix(setting, "BlobEndpoint="); ok {
+				if u, err := url.Parse(endpoint); err == nil {
+					address = u.Host
+				}
+			}
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", address, time.Second)
+	if err != nil {
+		t.Skipf("Azurite is not reachable at %s - %s", address, err)
+	}
+	conn.Close()
+
+	container := fmt.Sprintf("s3s2-%d", time.Now().UnixNano())
+	backend, err := newAzureBackend(&url.URL{Scheme: "az", Host: container, Path: "/prefix"},
+		options.Options{AzureConnectionString: connection_string})
+	require.NoError(t, err)
+	b := backend.(*azureBackend)
+
+	_, err = b.client.CreateContainer(context.Background(), container, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { b.client.DeleteContainer(context.Background(), container, nil) })
+	return b
+}
+
+func readAzureObject(t *testing.T, backend Backend, key string) string {
+	r, err := backend.Get(key)
+	require.NoError(t, err)
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestAzureRoundTrip(t *testing.T) {
+	backend := azuriteBackend(t)
+
+	local_path := filepath.Join(t.TempDir(), "a.txt")
+	require.NoError(t, os.WriteFile(local_path, []byte("top level file"), 0600))
+	require.NoError(t, backend.Put("ORG/batch/a.txt", local_path))
+	require.NoError(t, backend.PutStream("ORG/batch/nested/b.txt", strings.NewReader("nested file")))
+	require.NoError(t, backend.PutStream("OTHER/batch/c.txt", strings.NewReader("other org")))
+
+	assert.Equal(t, "top level file", readAzureObject(t, backend, "ORG/batch/a.txt"))
+	assert.Equal(t, "nested file", readAzureObject(t, backend, "ORG/batch/nested/b.txt"))
+
+	// keys are listed relative to the prefix of the destination
+	objects, err := backend.List("ORG/")
+	require.NoError(t, err)
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	assert.ElementsMatch(t, []string{"ORG/batch/a.txt", "ORG/batch/nested/b.txt"}, keys)
+
+	info, err := backend.Head("ORG/batch/nested/b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("nested file")), info.Size)
+
+	_, err = backend.Get("ORG/batch/missing.txt")
+	assert.Error(t, err)
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	options "github.com/tempuslabs/s3s2/options"
+	utils "github.com/tempuslabs/s3s2/utils"
+	"golang.org/x/sync/semaphore"
+)
+
+// Name of the empty marker object that tells downstream consumers a batch folder is complete
+const LambdaTriggerName = "._lambda_trigger"
+
+// ObjectInfo describes an object stored in a backend
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Backend is the set of object operations the share and decrypt pipelines need from a destination.
+// Keys are always posix-style and relative to the root of the backend (bucket plus any url path).
+type Backend interface {
+	// Put uploads the file at local_path to key
+	Put(key string, local_path string) error
+	// PutStream uploads everything read from body to key, the size does not need to be known up front
+	PutStream(key string, body io.Reader) error
+	// Get opens the object at key for reading, the caller must close the returned reader
+	Get(key string) (io.ReadCloser, error)
+	// Head returns the object's metadata without reading its contents
+	Head(key string) (ObjectInfo, error)
+	// List returns every object whose key starts with prefix
+	List(prefix string) ([]ObjectInfo, error)
+	// Delete removes the object at key
+	Delete(key string) error
+}
+
+// Factory builds a backend for a parsed destination url
+type Factory func(u *url.URL, opts options.Options) (Backend, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a backend available for destinations using the given url scheme.
+// Backends register themselves from an init function in their own file.
+func Register(scheme string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[scheme] = factory
+}
+
+// ParseDestination converts the bucket option into a url.
+// A bare bucket name is treated as s3://<bucket>, or gs://<bucket> when the legacy is-gcs flag is set.
+func ParseDestination(opts options.Options) (*url.URL, error) {
+	destination := opts.Bucket
+	if destination == "" {
+		return nil, fmt.Errorf("no bucket or destination url provided")
+	}
+
+	if !strings.Contains(destination, "://") {
+		if opts.IsGCS {
+			destination = "gs://" + destination
+		} else {
+			destination = "s3://" + destination
+		}
+	}
+
+	u, err := url.Parse(destination)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse destination '%s' - %s", destination, err)
+	}
+	return u, nil
+}
+
+// NewBackend returns the backend registered for the scheme of the bucket option
+func NewBackend(opts options.Options) (Backend, error) {
+	u, err := ParseDestination(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	registryMu.RLock()
+	factory, ok := registry[u.Scheme]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no storage backend registered for scheme '%s'", u.Scheme)
+	}
+
+	log.Debugf("Using '%s' storage backend for destination '%s'", u.Scheme, u.Redacted())
+	return factory(u, opts)
+}
+
+// memoryBudget caps the memory held by the part buffers of streamed uploads, shared by every worker using a backend
+type memoryBudget struct {
+	limit int64
+	sem   *semaphore.Weighted
+}
+
+// A limit of zero or less means no ceiling. A ceiling below footprint, the most a single upload holds at once, is refused
+// rather than letting that upload go over it.
+func newMemoryBudget(limit_mb int, footprint int64) (*memoryBudget, error) {
+	limit := int64(limit_mb) * 1024 * 1024
+	if limit <= 0 {
+		return &memoryBudget{}, nil
+	}
+	if limit < footprint {
+		return nil, fmt.Errorf("memory limit of %dMB is below the %dMB a single streamed upload needs", limit_mb, (footprint+1024*1024-1)/(1024*1024))
+	}
+	return &memoryBudget{limit: limit, sem: semaphore.NewWeighted(limit)}, nil
+}
+
+// acquire blocks until n bytes are available, the returned func gives them back.
+// Requests above the ceiling would block forever, they are clamped to it.
+func (m *memoryBudget) acquire(n int64) func() {
+	if m.sem == nil {
+		return func() {}
+	}
+	if n > m.limit {
+		n = m.limit
+	}
+	m.sem.Acquire(context.Background(), n)
+	return func() { m.sem.Release(n) }
+}
+
+// ObjectKey builds the key of an object belonging to an org - every org's objects live under its upper-cased name
+func ObjectKey(org string, key ...string) string {
+	return utils.ToPosixPath(filepath.Clean(filepath.Join(append([]string{strings.ToUpper(org)}, key...)...)))
+}
+
+// joinKey prefixes a key with the path component of a destination url
+func joinKey(prefix string, key string) string {
+	prefix = strings.Trim(prefix, "/")
+	key = strings.TrimPrefix(utils.ToPosixPath(key), "/")
+	if prefix == "" {
+		return key
+	}
+	return prefix + "/" + key
+}
+
+// UploadLambdaTrigger writes our empty trigger object - our way of communicating that s3s2 is done with a folder
+func UploadLambdaTrigger(b Backend, org string, folder string) error {
+	key := ObjectKey(org, folder, LambdaTriggerName)
+	log.Debugf("Uploading lambda trigger to key '%s'", key)
+	return b.PutStream(key, strings.NewReader(""))
+}
+
+// DownloadFile copies the object at key to target_path, returning the path written to
+func DownloadFile(b Backend, key string, target_path string) (string, error) {
+	log.Debugf("Downloading from key '%s' to file '%s'", key, target_path)
+
+	body, err := b.Get(key)
+	if err != nil {
+		log.Errorf("Error downloading file '%s'", key)
+		return target_path, err
+	}
+	defer body.Close()
+
+	file, err := os.Create(target_path)
+	if err != nil {
+		return target_path, err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, body)
+	if err != nil {
+		log.Errorf("Error downloading file '%s'", key)
+	}
+	return file.Name(), err
+}
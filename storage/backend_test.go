@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	options "github.com/tempuslabs/s3s2/options"
+)
+
+const mb = 1024 * 1024
+
+func TestMemoryBudgetRejectsLimitBelowFootprint(t *testing.T) {
+	_, err := newMemoryBudget(14, 15*mb)
+	assert.ErrorContains(t, err, "memory limit of 14MB is below the 15MB")
+
+	_, err = newS3Backend(&url.URL{Scheme: "s3", Host: "bucket"}, options.Options{MemoryLimit: 10})
+	assert.ErrorContains(t, err, "below the 15MB a single streamed upload needs")
+	_, err = newGCSBackend(&url.URL{Scheme: "gs", Host: "bucket"}, options.Options{MemoryLimit: 10})
+	assert.ErrorContains(t, err, "below the 16MB a single streamed upload needs")
+
+	budget, err := newMemoryBudget(15, 15*mb)
+	require.NoError(t, err)
+	assert.Equal(t, int64(15*mb), budget.limit)
+}
+
+func TestMemoryBudgetWithoutLimit(t *testing.T) {
+	budget, err := newMemoryBudget(0, 15*mb)
+	require.NoError(t, err)
+	release := budget.acquire(1024 * mb)
+	release()
+}
+
+// a request waits until enough of the ceiling has been given back
+func TestMemoryBudgetAcquireBlocksUntilReleased(t *testing.T) {
+	budget, err := newMemoryBudget(20, 15*mb)
+	require.NoError(t, err)
+	release := budget.acquire(15 * mb)
+
+	acquired := make(chan func())
+	go func() { acquired <- budget.acquire(15 * mb) }()
+
+	select {
+	case <-acquired:
+		t.Fatal("acquired more than the ceiling")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case second := <-acquired:
+		second()
+	case <-time.After(5 * time.Second):
+		t.Fatal("acquire did not return after the memory was released")
+	}
+	// everything was given back
+	assert.True(t, budget.sem.TryAcquire(20*mb))
+}
+
+// parts shrink so a single upload fits under the ceiling, down to the S3 minimum
+func TestS3StreamPartSize(t *testing.T) {
+	for limit_mb, part_size := range map[int]int64{0: streamPartSize, 1024: streamPartSize, 90: 30 * mb, 15: minPartSize} {
+		backend, err := newS3Backend(&url.URL{Scheme: "s3", Host: "bucket"}, options.Options{MemoryLimit: limit_mb})
+		require.NoError(t, err)
+		assert.Equal(t, part_size, backend.(*s3Backend).streamPartSize(), "limit %dMB", limit_mb)
+	}
+}
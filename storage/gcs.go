@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	gcs "cloud.google.com/go/storage"
+	"github.com/avast/retry-go/v4"
+	log "github.com/sirupsen/logrus"
+	options "github.com/tempuslabs/s3s2/options"
+	"google.golang.org/api/iterator"
+)
+
+// Every object writer buffers one chunk in memory before sending it
+const gcsChunkSize = 16 * 1024 * 1024
+
+func init() {
+	Register("gs", newGCSBackend)
+}
+
+type gcsBackend struct {
+	bucket string
+	prefix string
+	opts   options.Options
+	memory *memoryBudget
+}
+
+func newGCSBackend(u *url.URL, opts options.Options) (Backend, error) {
+	memory, err := newMemoryBudget(opts.MemoryLimit, gcsChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	return &gcsBackend{bucket: u.Host, prefix: u.Path, opts: opts, memory: memory}, nil
+}
+
+// gcsReader closes the client along with the object reader it was opened for
+type gcsReader struct {
+	*gcs.Reader
+	client *gcs.Client
+}
+
+func (r *gcsReader) Close() error {
+	err := r.Reader.Close()
+	r.client.Close()
+	return err
+}
+
+func (b *gcsBackend) write(ctx context.Context, client *gcs.Client, key string, body io.Reader) error {
+	release := b.memory.acquire(gcsChunkSize)
+	defer release()
+
+	// each chunk is buffered, so it can be sent again even though the body can't be read twice
+	object := client.Bucket(b.bucket).Object(joinKey(b.prefix, key)).Retryer(gcs.WithPolicy(gcs.RetryAlways))
+	wc := object.NewWriter(ctx)
+	wc.ContentType = "text/plain"
+	wc.ChunkSize = gcsChunkSize
+
+	if _, err := io.Copy(wc, body); err != nil {
+		log.Errorf("Failed to upload file while writing: %s", key)
+		wc.Close()
+		return err
+	}
+
+	if err := wc.Close(); err != nil {
+		log.Errorf("Failed to upload file while closing writer: %s", key)
+		return err
+	}
+
+	log.Debugf("Uploaded to: bucket = '%s', key = '%s'", b.bucket, key)
+	return nil
+}
+
+// Given file, open contents and send to GCS
+func (b *gcsBackend) Put(key string, local_path string) error {
+	log.Debugf("Uploading file '%s' to gcs key '%s'", local_path, key)
+
+	return retry.Do(
+		func() error {
+			ctx := context.Background()
+			client, err := gcs.NewClient(ctx)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			file, err := os.Open(local_path)
+			if err != nil {
+				return retry.Unrecoverable(err)
+			}
+			defer file.Close()
+
+			err = b.write(ctx, client, key, file)
+			if err != nil {
+				log.Infof("Retrying to upload file %s", key)
+			}
+			return err
+		},
+		retry.Attempts(5),
+	)
+}
+
+// A seekable body is rewound and sent again like Put does, a stream relies on its chunks being retried
+func (b *gcsBackend) PutStream(key string, body io.Reader) error {
+	seeker, seekable := body.(io.ReadSeeker)
+	attempts := uint(1)
+	if seekable {
+		attempts = 5
+	}
+
+	return retry.Do(
+		func() error {
+			ctx := context.Background()
+			client, err := gcs.NewClient(ctx)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			if seekable {
+				if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+					return retry.Unrecoverable(err)
+				}
+			}
+			err = b.write(ctx, client, key, body)
+			if err != nil && seekable {
+				log.Infof("Retrying to upload file %s", key)
+			}
+			return err
+		},
+		retry.Attempts(attempts),
+	)
+}
+
+func (b *gcsBackend) Get(key string) (io.ReadCloser, error) {
+	ctx := context.Background()
+	client, err := gcs.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := client.Bucket(b.bucket).Object(joinKey(b.prefix, key)).NewReader(ctx)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &gcsReader{Reader: rc, client: client}, nil
+}
+
+func (b *gcsBackend) Head(key string) (ObjectInfo, error) {
+	ctx := context.Background()
+	client, err := gcs.NewClient(ctx)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	defer client.Close()
+
+	attrs, err := client.Bucket(b.bucket).Object(joinKey(b.prefix, key)).Attrs(ctx)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: attrs.Size, LastModified: attrs.Updated}, nil
+}
+
+func (b *gcsBackend) List(prefix string) ([]ObjectInfo, error) {
+	ctx := context.Background()
+	client, err := gcs.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	var objects []ObjectInfo
+	root := joinKey(b.prefix, "")
+
+	it := client.Bucket(b.bucket).Objects(ctx, &gcs.Query{Prefix: joinKey(b.prefix, prefix)})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return objects, err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          strings.TrimPrefix(attrs.Name, root),
+			Size:         attrs.Size,
+			LastModified: attrs.Updated,
+		})
+	}
+	return objects, nil
+}
+
+func (b *gcsBackend) Delete(key string) error {
+	ctx := context.Background()
+	client, err := gcs.NewClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.Bucket(b.bucket).Object(joinKey(b.prefix, key)).Delete(ctx)
+}
@@ -0,0 +1,147 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	options "github.com/tempuslabs/s3s2/options"
+	utils "github.com/tempuslabs/s3s2/utils"
+)
+
+// Suffix marking objects that are still being written, these are never listed
+const partialSuffix = ".s3s2-partial"
+
+func init() {
+	Register("file", newLocalBackend)
+}
+
+// localBackend stores objects as files beneath a root directory, i.e. removable media or an NFS mount.
+// file:///mnt/dropbox is an absolute root, file://dropbox is relative to the working directory.
+type localBackend struct {
+	root string
+}
+
+func newLocalBackend(u *url.URL, opts options.Options) (Backend, error) {
+	root := filepath.Clean(filepath.FromSlash(u.Host + u.Path))
+	if root == "." || root == string(filepath.Separator) {
+		return nil, fmt.Errorf("refusing to use '%s' as the root of a local destination", root)
+	}
+	return &localBackend{root: root}, nil
+}
+
+// path resolves a key to a location beneath the root, rejecting keys that would escape it
+func (b *localBackend) path(key string) (string, error) {
+	p := filepath.Join(b.root, filepath.FromSlash(joinKey("", key)))
+	rel, err := filepath.Rel(b.root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("key '%s' resolves outside of '%s'", key, b.root)
+	}
+	return p, nil
+}
+
+// Given file, copy contents beneath the root
+func (b *localBackend) Put(key string, local_path string) error {
+	log.Debugf("Copying file '%s' to local key '%s'", local_path, key)
+
+	file, err := os.Open(local_path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return b.PutStream(key, file)
+}
+
+// Objects are written to a partial file first and renamed so readers never see half written objects
+func (b *localBackend) PutStream(key string, body io.Reader) error {
+	target, err := b.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(target), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), filepath.Base(target)+".*"+partialSuffix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = io.Copy(tmp, body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	log.Debugf("Wrote local object '%s'", target)
+	return os.Rename(tmp.Name(), target)
+}
+
+func (b *localBackend) Get(key string) (io.ReadCloser, error) {
+	target, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(target)
+}
+
+func (b *localBackend) Head(key string) (ObjectInfo, error) {
+	target, err := b.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()}, nil
+}
+
+func (b *localBackend) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+
+	err := filepath.WalkDir(b.root, func(file_path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(d.Name(), partialSuffix) {
+			return nil
+		}
+
+		key := utils.GetRelativePath(file_path, b.root)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return objects, nil
+	}
+	return objects, err
+}
+
+func (b *localBackend) Delete(key string) error {
+	target, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	return os.Remove(target)
+}
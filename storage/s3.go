@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/avast/retry-go/v4"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	log "github.com/sirupsen/logrus"
+	options "github.com/tempuslabs/s3s2/options"
+	utils "github.com/tempuslabs/s3s2/utils"
+)
+
+// 64MiB parts allow streamed objects of up to ~625GiB
+const (
+	streamPartSize    = 64 * 1024 * 1024
+	streamConcurrency = 2
+	minPartSize       = s3manager.MinUploadPartSize
+)
+
+// how long uploads wait for the pod's creds to be refreshed before trying again
+var retryDelay = 10 * time.Second
+
+func init() {
+	Register("s3", newS3Backend)
+}
+
+type s3Backend struct {
+	bucket string
+	prefix string
+	opts   options.Options
+	memory *memoryBudget
+}
+
+func newS3Backend(u *url.URL, opts options.Options) (Backend, error) {
+	// parts shrink to fit the ceiling, down to the smallest S3 accepts
+	memory, err := newMemoryBudget(opts.MemoryLimit, minPartSize*(streamConcurrency+1))
+	if err != nil {
+		return nil, err
+	}
+	return &s3Backend{bucket: u.Host, prefix: u.Path, opts: opts, memory: memory}, nil
+}
+
+// The uploader holds concurrency + 1 part buffers per streamed object.
+// Parts shrink when needed so a single upload fits within the memory ceiling.
+func (b *s3Backend) streamPartSize() int64 {
+	part_size := int64(streamPartSize)
+	if b.memory.limit > 0 && part_size*(streamConcurrency+1) > b.memory.limit {
+		part_size = b.memory.limit / (streamConcurrency + 1)
+	}
+	if part_size < minPartSize {
+		part_size = minPartSize
+	}
+	return part_size
+}
+
+// Fetch a session every call to make sure we have the latest creds from the pod
+func (b *s3Backend) client() *s3.S3 {
+	return s3.New(utils.GetAwsSession(b.opts), utils.GetS3Config(b.opts))
+}
+
+func (b *s3Backend) uploader() *s3manager.Uploader {
+	return s3manager.NewUploaderWithClient(b.client())
+}
+
+func (b *s3Backend) uploadInput(key string, body io.Reader) *s3manager.UploadInput {
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(joinKey(b.prefix, key)),
+		Body:   body,
+	}
+	if b.opts.AwsKey != "" {
+		input.ServerSideEncryption = aws.String("aws:kms")
+		input.SSEKMSKeyId = aws.String(b.opts.AwsKey)
+	}
+	return input
+}
+
+// Given file, open contents and send to S3
+func (b *s3Backend) Put(key string, local_path string) error {
+	log.Debugf("Uploading file '%s' to aws key '%s'", local_path, key)
+
+	// The pod will have empty creds while refreshing the session, in that case we will retry after 10 secs
+	return retry.Do(
+		func() error {
+			file, err := os.Open(local_path)
+			if err != nil {
+				return retry.Unrecoverable(err)
+			}
+			defer file.Close()
+
+			uploader := b.uploader()
+			result, err := uploader.Upload(b.uploadInput(key, file))
+			if err != nil {
+				time.Sleep(retryDelay)
+				return err
+			}
+			log.Debugf("File '%s' uploaded to: '%s'", local_path, result.Location)
+			return nil
+		},
+		retry.Attempts(3),
+	)
+}
+
+// Given a reader, stream contents to S3 as a multipart upload
+func (b *s3Backend) PutStream(key string, body io.Reader) error {
+	log.Debugf("Streaming upload to aws key '%s'", key)
+
+	// the size of a stream is unknown so the uploader can't grow its parts to fit,
+	// large parts keep multi-hundred-GB objects under S3's 10,000 part limit.
+	// every in flight part is buffered in memory, so fewer of them are uploaded at once
+	seeker, seekable := body.(io.ReadSeeker)
+	var part_size int64
+	if !seekable {
+		part_size = b.streamPartSize()
+		release := b.memory.acquire(part_size * (streamConcurrency + 1))
+		defer release()
+	}
+
+	// Like Put, retries while the pod refreshes its creds. A seekable body is rewound and sent again, a stream can't be
+	// read twice so only waits for creds before it is read, each of its parts is still retried by the SDK.
+	return retry.Do(
+		func() error {
+			client := b.client()
+			if seekable {
+				if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+					return retry.Unrecoverable(err)
+				}
+			} else if _, err := client.Config.Credentials.Get(); err != nil {
+				time.Sleep(retryDelay)
+				return err
+			}
+
+			uploader := s3manager.NewUploaderWithClient(client)
+			if !seekable {
+				uploader.PartSize = part_size
+				uploader.Concurrency = streamConcurrency
+			}
+			result, err := uploader.Upload(b.uploadInput(key, body))
+			if err != nil {
+				if !seekable {
+					return retry.Unrecoverable(err)
+				}
+				time.Sleep(retryDelay)
+				return err
+			}
+			log.Debugf("Stream uploaded to: '%s'", result.Location)
+			return nil
+		},
+		retry.Attempts(3),
+	)
+}
+
+func (b *s3Backend) Get(key string) (io.ReadCloser, error) {
+	out, err := b.client().GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(joinKey(b.prefix, key)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (b *s3Backend) Head(key string) (ObjectInfo, error) {
+	out, err := b.client().HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(joinKey(b.prefix, key)),
+	})
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(out.ContentLength),
+		LastModified: aws.TimeValue(out.LastModified),
+	}, nil
+}
+
+func (b *s3Backend) List(prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	root := joinKey(b.prefix, "")
+
+	err := b.client().ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(b.bucket),
+		Prefix: aws.String(joinKey(b.prefix, prefix)),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          strings.TrimPrefix(aws.StringValue(obj.Key), root),
+				Size:         aws.Int64Value(obj.Size),
+				LastModified: aws.TimeValue(obj.LastModified),
+			})
+		}
+		return true
+	})
+	return objects, err
+}
+
+func (b *s3Backend) Delete(key string) error {
+	_, err := b.client().DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(b.bucket),
+		Key:    aws.String(joinKey(b.prefix, key)),
+	})
+	return err
+}
@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	options "github.com/tempuslabs/s3s2/options"
+)
+
+// an S3 endpoint refusing the first upload of every object with an error the SDK does not retry itself
+func flakyS3(t *testing.T) (*httptest.Server, map[string][]string) {
+	var mu sync.Mutex
+	uploads := make(map[string][]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		uploads[r.URL.Path] = append(uploads[r.URL.Path], string(body))
+		if len(uploads[r.URL.Path]) == 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`<Error><Code>BadRequest</Code><Message>try again</Message></Error>`))
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+	}))
+	t.Cleanup(server.Close)
+	return server, uploads
+}
+
+func flakyS3Backend(t *testing.T) (Backend, map[string][]string) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "s3s2")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "s3s2")
+	delay := retryDelay
+	retryDelay = 0
+	t.Cleanup(func() { retryDelay = delay })
+
+	server, uploads := flakyS3(t)
+	backend, err := newS3Backend(&url.URL{Scheme: "s3", Host: "bucket"},
+		options.Options{Region: "us-east-1", EndpointUrl: server.URL, PathStyle: true})
+	require.NoError(t, err)
+	return backend, uploads
+}
+
+// a body that can be rewound is sent again in full
+func TestS3PutStreamRetriesSeekableBody(t *testing.T) {
+	backend, uploads := flakyS3Backend(t)
+
+	require.NoError(t, backend.PutStream("ORG/batch/s3s2_manifest.json", bytes.NewReader([]byte("manifest"))))
+	assert.Equal(t, []string{"manifest", "manifest"}, uploads["/bucket/ORG/batch/s3s2_manifest.json"])
+}
+
+// a stream can't be read twice, sending what is left of it would upload a truncated object
+func TestS3PutStreamFailsStreamOnce(t *testing.T) {
+	backend, uploads := flakyS3Backend(t)
+
+	err := backend.PutStream("ORG/batch/a.txt.zip.gpg", io.MultiReader(strings.NewReader("object")))
+	assert.Error(t, err)
+	assert.Len(t, uploads["/bucket/ORG/batch/a.txt.zip.gpg"], 1)
+}
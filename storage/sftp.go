@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path"
+	"strings"
+	"sync"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+	options "github.com/tempuslabs/s3s2/options"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func init() {
+	Register("sftp", newSftpBackend)
+}
+
+// sftpBackend stores objects as files beneath a directory of an SFTP server, sftp://user@host:port/directory
+type sftpBackend struct {
+	address string
+	root    string
+	config  *ssh.ClientConfig
+
+	mu     sync.Mutex
+	ssh    *ssh.Client
+	client *sftp.Client
+}
+
+// Builds the ssh config - only key based auth, and the server must be present in known_hosts
+func newSftpConfig(u *url.URL, opts options.Options) (*ssh.ClientConfig, error) {
+	username := u.User.Username()
+	if username == "" {
+		current, err := user.Current()
+		if err != nil {
+			return nil, err
+		}
+		username = current.Username
+	}
+
+	key_path := opts.SftpKey
+	if key_path == "" {
+		key_path = "~/.ssh/id_rsa"
+	}
+	key_path, err := homedir.Expand(key_path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := os.ReadFile(key_path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read sftp private key '%s' - %s", key_path, err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse sftp private key '%s' - %s", key_path, err)
+	}
+
+	known_hosts := opts.SftpKnownHosts
+	if known_hosts == "" {
+		known_hosts = "~/.ssh/known_hosts"
+	}
+	known_hosts, err = homedir.Expand(known_hosts)
+	if err != nil {
+		return nil, err
+	}
+	host_key_callback, err := knownhosts.New(known_hosts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load known hosts '%s' - %s", known_hosts, err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: host_key_callback,
+	}, nil
+}
+
+func newSftpBackend(u *url.URL, opts options.Options) (Backend, error) {
+	config, err := newSftpConfig(u, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	root := path.Clean("/" + u.Path)
+	if root == "/" {
+		return nil, fmt.Errorf("an sftp destination must include the directory to write to")
+	}
+
+	return &sftpBackend{address: address, root: root, config: config}, nil
+}
+
+// Returns the shared sftp client, dialing the server on first use or after the connection was lost
+func (b *sftpBackend) conn() (*sftp.Client, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.client != nil {
+		return b.client, nil
+	}
+
+	log.Debugf("Connecting to sftp server '%s' as '%s'", b.address, b.config.User)
+	ssh_client, err := ssh.Dial("tcp", b.address, b.config)
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(ssh_client)
+	if err != nil {
+		ssh_client.Close()
+		return nil, err
+	}
+
+	b.ssh = ssh_client
+	b.client = client
+
+	// forget the connection once it drops so the next call reconnects
+	go func() {
+		ssh_client.Wait()
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.ssh == ssh_client {
+			log.Debugf("Lost connection to sftp server '%s'", b.address)
+			b.ssh = nil
+			b.client = nil
+		}
+	}()
+	return client, nil
+}
+
+// remote paths are always posix, and keys may not escape the root directory
+func (b *sftpBackend) path(key string) (string, error) {
+	p := path.Join(b.root, joinKey("", key))
+	if p != b.root && !strings.HasPrefix(p, b.root+"/") {
+		return "", fmt.Errorf("key '%s' resolves outside of '%s'", key, b.root)
+	}
+	return p, nil
+}
+
+// Given file, open contents and send to the sftp server
+func (b *sftpBackend) Put(key string, local_path string) error {
+	log.Debugf("Uploading file '%s' to sftp key '%s'", local_path, key)
+
+	file, err := os.Open(local_path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return b.PutStream(key, file)
+}
+
+// Objects are written to a partial file first and renamed so partners never pick up half written objects
+func (b *sftpBackend) PutStream(key string, body io.Reader) error {
+	client, err := b.conn()
+	if err != nil {
+		return err
+	}
+	target, err := b.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err = client.MkdirAll(path.Dir(target)); err != nil {
+		return err
+	}
+
+	// a name of its own, so concurrent uploads of the same key never write to the same partial file
+	suffix := make([]byte, 8)
+	if _, err = rand.Read(suffix); err != nil {
+		return err
+	}
+	partial := fmt.Sprintf("%s.%x%s", target, suffix, partialSuffix)
+	remote, err := client.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return err
+	}
+
+	if _, err = remote.ReadFrom(body); err != nil {
+		remote.Close()
+		client.Remove(partial)
+		return err
+	}
+	if err = remote.Close(); err != nil {
+		client.Remove(partial)
+		return err
+	}
+
+	// prefer the atomic posix-rename extension. Plain sftp rename fails when the target exists, removing it first would
+	// leave a window without the object, so servers without the extension can't replace objects
+	if err = client.PosixRename(partial, target); err != nil {
+		if err = client.Rename(partial, target); err != nil {
+			client.Remove(partial)
+			return fmt.Errorf("unable to move '%s' into place, the server does not support posix-rename so existing objects can't be replaced - %s", target, err)
+		}
+	}
+	return nil
+}
+
+func (b *sftpBackend) Get(key string) (io.ReadCloser, error) {
+	client, err := b.conn()
+	if err != nil {
+		return nil, err
+	}
+	target, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Open(target)
+}
+
+func (b *sftpBackend) Head(key string) (ObjectInfo, error) {
+	client, err := b.conn()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	target, err := b.path(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	info, err := client.Stat(target)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()}, nil
+}
+
+func (b *sftpBackend) List(prefix string) ([]ObjectInfo, error) {
+	client, err := b.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []ObjectInfo
+	walker := client.Walk(b.root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return objects, err
+		}
+
+		info := walker.Stat()
+		if info.IsDir() || strings.HasSuffix(info.Name(), partialSuffix) {
+			continue
+		}
+
+		key := strings.TrimPrefix(walker.Path(), b.root+"/")
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, ObjectInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		}
+	}
+	return objects, nil
+}
+
+func (b *sftpBackend) Delete(key string) error {
+	client, err := b.conn()
+	if err != nil {
+		return err
+	}
+	target, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	return client.Remove(target)
+}
@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hides the posix-rename extension of the in memory handler, like servers that don't offer it
+type plainRenames struct {
+	sftp.FileCmder
+}
+
+// an sftp backend talking to an in memory server over a pipe
+func memorySftpBackend(t *testing.T, handlers sftp.Handlers) *sftpBackend {
+	client_conn, server_conn := net.Pipe()
+	server := sftp.NewRequestServer(server_conn, handlers)
+	go server.Serve()
+	t.Cleanup(func() { server.Close() })
+
+	client, err := sftp.NewClientPipe(client_conn, client_conn)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return &sftpBackend{root: "/data", client: client}
+}
+
+func readSftpObject(t *testing.T, backend Backend, key string) string {
+	r, err := backend.Get(key)
+	require.NoError(t, err)
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(content)
+}
+
+func TestSftpPutStreamReplacesObjects(t *testing.T) {
+	backend := memorySftpBackend(t, sftp.InMemHandler())
+
+	require.NoError(t, backend.PutStream("ORG/batch/a.txt", strings.NewReader("first")))
+	require.NoError(t, backend.PutStream("ORG/batch/a.txt", strings.NewReader("second")))
+	assert.Equal(t, "second", readSftpObject(t, backend, "ORG/batch/a.txt"))
+
+	objects, err := backend.List("ORG/")
+	require.NoError(t, err)
+	require.Len(t, objects, 1)
+	assert.Equal(t, "ORG/batch/a.txt", objects[0].Key)
+}
+
+// without posix-rename new objects are still written, existing ones are left alone rather than removed first
+func TestSftpPutStreamWithoutPosixRename(t *testing.T) {
+	handlers := sftp.InMemHandler()
+	handlers.FileCmd = plainRenames{handlers.FileCmd}
+	backend := memorySftpBackend(t, handlers)
+
+	require.NoError(t, backend.PutStream("ORG/batch/a.txt", strings.NewReader("first")))
+	err := backend.PutStream("ORG/batch/a.txt", strings.NewReader("second"))
+	assert.ErrorContains(t, err, "does not support posix-rename")
+	assert.Equal(t, "first", readSftpObject(t, backend, "ORG/batch/a.txt"))
+
+	// the partial file was cleaned up
+	names, err := backend.client.ReadDir("/data/ORG/batch")
+	require.NoError(t, err)
+	require.Len(t, names, 1)
+	assert.Equal(t, "a.txt", names[0].Name())
+}
@@ -0,0 +1,59 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	manifest "github.com/tempuslabs/s3s2/manifest"
+	storage "github.com/tempuslabs/s3s2/storage"
+)
+
+func read_manifest(rt *round_trip) manifest.Manifest {
+	target := filepath.Join(rt.t.TempDir(), "s3s2_manifest.json")
+	_, err := storage.DownloadFile(local_backend(rt.t, rt.destination), rt.manifest_key(), target)
+	require.NoError(rt.t, err)
+	return manifest.ReadManifest(target)
+}
+
+// the manifest records the receivers, sender and algorithms of the batch
+func TestManifestRecordsCrypto(t *testing.T) {
+	rt := new_round_trip(t)
+	_, sender_priv := sender_keys(t, "sender")
+	escrow_pub, _ := sender_keys(t, "escrow")
+
+	rt.pub_key = rt.pub_key + "," + escrow_pub
+	rt.share("--binary", "--compression", "zstd", "--sender-private-key", sender_priv)
+
+	crypto := read_manifest(rt).Crypto
+	require.NotNil(t, crypto)
+	require.Len(t, crypto.Recipients, 2)
+	assert.Equal(t, strings.ReplaceAll(test_key_fingerprint, " ", ""), crypto.Recipients[0].Fingerprint)
+	assert.NotEmpty(t, crypto.Recipients[1].KeyId)
+	assert.Equal(t, "AES-256", crypto.Cipher)
+	assert.Equal(t, "SHA-256", crypto.Hash)
+	assert.Equal(t, "zstd", crypto.Compression)
+	assert.False(t, crypto.Armored)
+	assert.NotEmpty(t, crypto.Signer)
+	assert.NotEmpty(t, crypto.Version)
+}
+
+// a private key the batch isn't encrypted to is refused before any object is downloaded
+func TestDecryptRefusesMismatchedKey(t *testing.T) {
+	rt := new_round_trip(t)
+	rt.share()
+
+	_, rt.priv_key = sender_keys(t, "stranger")
+	out, err := rt.try_decrypt()
+	assert.Error(t, err)
+	assert.Contains(t, out, "does not match any key the batch is encrypted to")
+
+	downloaded, err := os.ReadDir(rt.decrypted)
+	require.NoError(t, err)
+	for _, entry := range downloaded {
+		assert.Equal(t, "s3s2_manifest.json", entry.Name())
+	}
+}
@@ -9,9 +9,10 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	compress "github.com/tempuslabs/s3s2/compress"
 	encrypt "github.com/tempuslabs/s3s2/encrypt"
 	"github.com/tempuslabs/s3s2/options"
-	"golang.org/x/crypto/openpgp/packet"
+	"github.com/ProtonMail/go-crypto/openpgp"
 )
 
 // helper functions to prepare tests
@@ -44,14 +45,14 @@ func cryptFileExists(filename string) bool {
     return true
 }
 
-func read_pub_key() *packet.PublicKey {
+func read_pub_key() openpgp.EntityList {
     log.Info(os.Getwd())
     sess, _ := session.NewSession()
-    pub_key := encrypt.GetPubKey(sess, get_options())
+    pub_key := encrypt.GetPubKeys(sess, get_options())
     return pub_key
 }
 
-func read_priv_key() *packet.PrivateKey {
+func read_priv_key() openpgp.EntityList {
     log.Info(os.Getwd())
     sess, _ := session.NewSession()
     priv_key := encrypt.GetPrivKey(sess, get_options())
@@ -71,7 +72,7 @@ func TestEncryptFile(t * testing.T) {
     assert := assert.New(t)
 
     pub_key := read_pub_key()
-    encrypt.EncryptFile(pub_key, fn_in, fn_out, get_options())
+    encrypt.EncryptFile(pub_key, nil, fn_in, fn_out, compress.Gzip, get_options())
 
     assert.True(cryptFileExists(fn_out))
 
@@ -85,7 +86,7 @@ func TestEncryptBuffer(t * testing.T) {
     assert := assert.New(t)
 
     pub_key := read_pub_key()
-    result := encrypt.EncryptBuffer(pub_key, fn_in, get_options())
+    result := encrypt.EncryptBuffer(pub_key, nil, fn_in, compress.Gzip, get_options())
 
     assert.True(result.Bytes() != nil)
 
@@ -100,12 +101,12 @@ func TestDecryptFile(t * testing.T) {
     // ensure the fn_out is created as a part of this test
     os.RemoveAll(fn_out)
 
-    pub_key := read_pub_key()
     priv_key := read_priv_key()
 
-    encrypt.DecryptFile(pub_key, priv_key, fn_in, fn_out, get_options())
+    err := encrypt.DecryptFile(priv_key, nil, fn_in, fn_out, "", compress.Gzip, get_options())
 
     assert := assert.New(t)
+    assert.NoError(err)
 
     assert.True(cryptFileExists(fn_out))
 
@@ -0,0 +1,158 @@
+package main_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compress "github.com/tempuslabs/s3s2/compress"
+	encrypt "github.com/tempuslabs/s3s2/encrypt"
+	manifest "github.com/tempuslabs/s3s2/manifest"
+	options "github.com/tempuslabs/s3s2/options"
+	storage "github.com/tempuslabs/s3s2/storage"
+)
+
+// a stand-in for KMS whose wrapped data keys are simply the plaintext with a prefix
+func fake_kms(calls map[string]int, mu *sync.Mutex) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var request struct{ KeyId, CiphertextBlob string }
+		json.NewDecoder(r.Body).Decode(&request)
+
+		response := map[string]string{"KeyId": "arn:aws:kms:us-east-1:000000000000:key/s3s2"}
+		switch operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "TrentService."); operation {
+		case "GenerateDataKey":
+			calls[operation]++
+			key := make([]byte, 32)
+			rand.Read(key)
+			response["Plaintext"] = base64.StdEncoding.EncodeToString(key)
+			response["CiphertextBlob"] = base64.StdEncoding.EncodeToString(append([]byte("wrapped:"), key...))
+		case "Decrypt":
+			calls[operation]++
+			blob, _ := base64.StdEncoding.DecodeString(request.CiphertextBlob)
+			response["Plaintext"] = base64.StdEncoding.EncodeToString(bytes.TrimPrefix(blob, []byte("wrapped:")))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		json.NewEncoder(w).Encode(response)
+	}))
+}
+
+func seal(t *testing.T, key []byte, data []byte) []byte {
+	var sealed bytes.Buffer
+	w, err := encrypt.NewEnvelopeWriter(key, &sealed, compress.None, options.Options{})
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return sealed.Bytes()
+}
+
+func open_sealed(key []byte, sealed []byte) ([]byte, error) {
+	r, err := encrypt.NewEnvelopeReader(key, bytes.NewReader(sealed), compress.None)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// sealed objects span several segments, and any change to them is refused
+func TestEnvelopeSealOpen(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	data := make([]byte, 200*1024)
+	rand.Read(data)
+
+	sealed := seal(t, key, data)
+	opened, err := open_sealed(key, sealed)
+	require.NoError(t, err)
+	assert.Equal(t, data, opened)
+
+	empty, err := open_sealed(key, seal(t, key, nil))
+	require.NoError(t, err)
+	assert.Empty(t, empty)
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)/2] ^= 0xff
+	_, err = open_sealed(key, tampered)
+	assert.ErrorContains(t, err, "failed authentication")
+
+	// dropping the final segment leaves a stream that ends on a full one
+	segment := 64*1024 + 16
+	_, err = open_sealed(key, sealed[:len(sealed)-(len(sealed)-19)%segment])
+	assert.ErrorContains(t, err, "failed authentication")
+
+	other := make([]byte, 32)
+	rand.Read(other)
+	_, err = open_sealed(other, sealed)
+	assert.Error(t, err)
+}
+
+// a batch sealed with KMS data keys decrypts without any GPG keys
+func TestKmsEnvelopeShare(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "s3s2")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "s3s2")
+
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	server := fake_kms(calls, &mu)
+	defer server.Close()
+
+	rt := new_round_trip(t)
+	rt.pub_key, rt.priv_key = "", ""
+	rt.share("--kms-key-id", "alias/s3s2", "--kms-endpoint-url", server.URL)
+
+	target := filepath.Join(t.TempDir(), "s3s2_manifest.json")
+	_, err := storage.DownloadFile(local_backend(t, rt.destination), rt.manifest_key(), target)
+	require.NoError(t, err)
+	m := manifest.ReadManifest(target)
+	assert.Equal(t, encrypt.FormatKMS, m.Format)
+	for _, f := range m.Files {
+		assert.NotEmpty(t, f.DataKey, f.Name)
+	}
+	assert.Equal(t, len(m.Files), calls["GenerateDataKey"])
+
+	rt.decrypt("--kms-endpoint-url", server.URL)
+	rt.assert_decrypted()
+
+	rt.decrypted = t.TempDir()
+	rt.decrypt("--streaming", "--kms-endpoint-url", server.URL)
+	rt.assert_decrypted()
+	assert.Equal(t, 2*len(m.Files), calls["Decrypt"])
+}
+
+// envelope objects are not signed, the sender key signs the manifest that lists every data key
+func TestKmsEnvelopeSignsManifestOnly(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "s3s2")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "s3s2")
+
+	var mu sync.Mutex
+	server := fake_kms(make(map[string]int), &mu)
+	defer server.Close()
+
+	rt := new_round_trip(t)
+	sender_pub, sender_priv := sender_keys(t, "sender")
+	rt.pub_key, rt.priv_key = "", ""
+	out, err := rt.try_share("--kms-key-id", "alias/s3s2", "--kms-endpoint-url", server.URL, "--sender-private-key", sender_priv)
+	require.NoError(t, err, out)
+	assert.Contains(t, out, "the sender key only signs the manifest")
+
+	_, err = local_backend(t, rt.destination).Head(rt.manifest_key() + manifest.SignatureSuffix)
+	require.NoError(t, err)
+	rt.decrypt("--kms-endpoint-url", server.URL, "--strict", "--trusted-senders", sender_pub)
+	rt.assert_decrypted()
+}
@@ -13,21 +13,21 @@ func TestChunkSplitEven(t * testing.T) {
     assert := assert.New(t)
 
     array := []file.File{
-    file.File{"testfile0"},
-    file.File{"testfile1"},
-    file.File{"testfile2"},
-    file.File{"testfile3"},
-    file.File{"testfile4"},
-    file.File{"testfile5"},
-    file.File{"testfile6"},
-    file.File{"testfile7"},
+    file.File{Name: "testfile0"},
+    file.File{Name: "testfile1"},
+    file.File{Name: "testfile2"},
+    file.File{Name: "testfile3"},
+    file.File{Name: "testfile4"},
+    file.File{Name: "testfile5"},
+    file.File{Name: "testfile6"},
+    file.File{Name: "testfile7"},
     }
 
     expected := [][]file.File{
-    []file.File{file.File{"testfile0"}, file.File{"testfile1"}},
-    []file.File{file.File{"testfile2"}, file.File{"testfile3"}},
-    []file.File{file.File{"testfile4"}, file.File{"testfile5"}},
-    []file.File{file.File{"testfile6"}, file.File{"testfile7"}},
+    []file.File{file.File{Name: "testfile0"}, file.File{Name: "testfile1"}},
+    []file.File{file.File{Name: "testfile2"}, file.File{Name: "testfile3"}},
+    []file.File{file.File{Name: "testfile4"}, file.File{Name: "testfile5"}},
+    []file.File{file.File{Name: "testfile6"}, file.File{Name: "testfile7"}},
     }
 
     actual := file.ChunkArray(array, 2)
@@ -39,23 +39,23 @@ func TestChunkSplitOdd(t * testing.T) {
     assert := assert.New(t)
 
     array := []file.File{
-    file.File{"testfile0"},
-    file.File{"testfile1"},
-    file.File{"testfile2"},
-    file.File{"testfile3"},
-    file.File{"testfile4"},
-    file.File{"testfile5"},
-    file.File{"testfile6"},
-    file.File{"testfile7"},
-    file.File{"testfile8"},
-    file.File{"testfile9"},
+    file.File{Name: "testfile0"},
+    file.File{Name: "testfile1"},
+    file.File{Name: "testfile2"},
+    file.File{Name: "testfile3"},
+    file.File{Name: "testfile4"},
+    file.File{Name: "testfile5"},
+    file.File{Name: "testfile6"},
+    file.File{Name: "testfile7"},
+    file.File{Name: "testfile8"},
+    file.File{Name: "testfile9"},
     }
 
     expected := [][]file.File{
-    []file.File{file.File{"testfile0"},file.File{"testfile1"},file.File{"testfile2"}},
-    []file.File{file.File{"testfile3"},file.File{"testfile4"},file.File{"testfile5"}},
-    []file.File{file.File{"testfile6"},file.File{"testfile7"},file.File{"testfile8"}},
-    []file.File{file.File{"testfile9"}},
+    []file.File{file.File{Name: "testfile0"},file.File{Name: "testfile1"},file.File{Name: "testfile2"}},
+    []file.File{file.File{Name: "testfile3"},file.File{Name: "testfile4"},file.File{Name: "testfile5"}},
+    []file.File{file.File{Name: "testfile6"},file.File{Name: "testfile7"},file.File{Name: "testfile8"}},
+    []file.File{file.File{Name: "testfile9"}},
     }
 
     actual := file.ChunkArray(array, 3)
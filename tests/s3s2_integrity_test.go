@@ -0,0 +1,96 @@
+package main_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	file "github.com/tempuslabs/s3s2/file"
+	manifest "github.com/tempuslabs/s3s2/manifest"
+	storage "github.com/tempuslabs/s3s2/storage"
+)
+
+func TestFileStampAndVerify(t *testing.T) {
+	assert := assert.New(t)
+	source := filepath.Join(t.TempDir(), "a.txt")
+	require.NoError(t, writeToFile(source, "top level file"))
+
+	fs := file.File{Name: "a.txt"}
+	assert.False(fs.Stamped())
+	opened, err := fs.Open(source, true)
+	require.NoError(t, err)
+	_, err = io.Copy(io.Discard, opened)
+	require.NoError(t, err)
+	require.NoError(t, opened.Close())
+	assert.Equal(int64(14), fs.Size)
+	assert.Equal("ec86b793541755ff08c98a1cf62da484a34b665feda9759f511dc86d4c3e30cc", fs.Sha256)
+	assert.NoError(fs.Verify(source))
+
+	copied := filepath.Join(t.TempDir(), "a.txt")
+	require.NoError(t, writeToFile(copied, "top level fil3"))
+	assert.ErrorContains(fs.Verify(copied), "sha256 mismatch")
+
+	require.NoError(t, writeToFile(copied, "short"))
+	assert.ErrorContains(fs.Verify(copied), "size mismatch")
+
+	// names alone, as in manifests from before checksums, have nothing to verify
+	assert.NoError((&file.File{Name: "a.txt"}).Verify(copied))
+}
+
+func read_report(t *testing.T, directory string) *manifest.Report {
+	reports, _ := filepath.Glob(filepath.Join(directory, "s3s2_integrity_*.json"))
+	require.Len(t, reports, 1)
+	data, err := os.ReadFile(reports[0])
+	require.NoError(t, err)
+
+	report := &manifest.Report{}
+	require.NoError(t, jsoniter.Unmarshal(data, report))
+	return report
+}
+
+// every decrypted file is checked against the checksum share recorded
+func TestDecryptVerifiesChecksums(t *testing.T) {
+	rt := new_round_trip(t)
+	rt.share()
+	rt.decrypt()
+	rt.assert_decrypted()
+
+	report := read_report(t, rt.decrypted)
+	assert.Equal(t, 2, report.Verified)
+	assert.Empty(t, report.Failures)
+}
+
+func TestDecryptReportsChecksumMismatch(t *testing.T) {
+	rt := new_round_trip(t)
+	rt.share()
+
+	// tamper with the recorded checksum of a.txt
+	backend := local_backend(t, rt.destination)
+	target := filepath.Join(t.TempDir(), "s3s2_manifest.json")
+	_, err := storage.DownloadFile(backend, rt.manifest_key(), target)
+	require.NoError(t, err)
+	m := manifest.ReadManifest(target)
+	for i := range m.Files {
+		require.NotEmpty(t, m.Files[i].Sha256)
+		if m.Files[i].Name == "a.txt" {
+			m.Files[i].Sha256 = "0000"
+		}
+	}
+	data, err := m.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, backend.PutStream(rt.manifest_key(), bytes.NewReader(data)))
+
+	out, err := rt.try_decrypt()
+	assert.Error(t, err, out)
+
+	report := read_report(t, rt.decrypted)
+	assert.Equal(t, 1, report.Verified)
+	require.Len(t, report.Failures, 1)
+	assert.Equal(t, "a.txt", report.Failures[0].Name)
+	assert.Contains(t, report.Failures[0].Error, "sha256 mismatch")
+}
@@ -0,0 +1,102 @@
+package main_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	file "github.com/tempuslabs/s3s2/file"
+	journal "github.com/tempuslabs/s3s2/journal"
+	manifest "github.com/tempuslabs/s3s2/manifest"
+	options "github.com/tempuslabs/s3s2/options"
+	storage "github.com/tempuslabs/s3s2/storage"
+)
+
+func TestJournalReplay(t *testing.T) {
+	assert := assert.New(t)
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+
+	j, err := journal.Create(path, "20240101120000", "20240101", options.Options{Org: "org", Prefix: "clinical", Directory: "/data"})
+	require.NoError(t, err)
+	assert.NoError(j.StartBatch("clinical_0"))
+	assert.NoError(j.RecordUpload("clinical_0", file.File{Name: "a.txt"}))
+	assert.NoError(j.StartBatch("clinical_1"))
+	assert.NoError(j.RecordUpload("clinical_1", file.File{Name: "b.txt"}))
+	assert.NoError(j.Close())
+
+	// a crash mid-write leaves a torn last line behind
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	f.WriteString(`{"batch":"clinical_1","fi`)
+	f.Close()
+
+	j, err = journal.Open(path)
+	require.NoError(t, err)
+	defer j.Close()
+
+	assert.Equal("20240101120000", j.Header.Fnuuid)
+	assert.Equal("clinical_1", j.CurrentBatch())
+	assert.True(j.Has("clinical_0", "a.txt"))
+	assert.False(j.Has("clinical_1", "a.txt"))
+	assert.False(j.Triggered["clinical_0"])
+
+	pending, uploaded := j.Split([]file.File{{Name: "a.txt"}, {Name: "b.txt"}, {Name: "c.txt"}})
+	assert.Equal([]file.File{{Name: "c.txt"}}, pending)
+	assert.Len(uploaded, 2)
+}
+
+// a share that died after uploading one file picks up where it stopped
+func TestResumeShare(t *testing.T) {
+	assert := assert.New(t)
+	rt := new_round_trip(t)
+	scratch := t.TempDir()
+	backend := local_backend(t, rt.destination)
+
+	rt.share("--scratch-directory", scratch)
+
+	journals, _ := filepath.Glob(filepath.Join(scratch, "s3s2_journal_*.jsonl"))
+	require.Len(t, journals, 1)
+
+	// rewind the journal and the destination to just after the first upload
+	data, err := os.ReadFile(journals[0])
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(data), "\n")
+	require.NoError(t, os.WriteFile(journals[0], []byte(strings.Join(lines[:3], "")), 0600))
+
+	batch := filepath.Dir(rt.manifest_key())
+	objects, err := backend.List(batch + "/")
+	require.NoError(t, err)
+	for _, obj := range objects {
+		require.NoError(t, backend.Delete(obj.Key))
+	}
+
+	rt.share("--scratch-directory", scratch, "--resume", journals[0])
+
+	// only the file missing from the journal is uploaded again, into the same batch folder
+	objects, err = backend.List(batch + "/")
+	require.NoError(t, err)
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, strings.TrimPrefix(obj.Key, batch+"/"))
+	}
+	assert.Len(keys, 3)
+	assert.Contains(keys, "s3s2_manifest.json")
+	assert.Contains(keys, storage.LambdaTriggerName)
+
+	target := filepath.Join(t.TempDir(), "s3s2_manifest.json")
+	_, err = storage.DownloadFile(backend, rt.manifest_key(), target)
+	require.NoError(t, err)
+	m := manifest.ReadManifest(target)
+	var names []string
+	for _, fs := range m.Files {
+		names = append(names, fs.Name)
+		// checksums of files uploaded before the interruption come back from the journal
+		assert.NotEmpty(fs.Sha256)
+	}
+	assert.ElementsMatch([]string{"a.txt", "nested/b.txt"}, names)
+
+	// resuming a finished share does nothing
+	rt.share("--scratch-directory", scratch, "--resume", journals[0])
+}
@@ -0,0 +1,92 @@
+package main_test
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the fingerprint of resources/testkey.pubkey, as printed by gpg
+const test_key_fingerprint = "4877 F9B3 F0B4 1805 BDC8  494A 3A4D 941A 3171 F282"
+
+// serves whatever key file is current at /.well-known/s3s2.asc, the returned file trusts the server's certificate
+func key_server(t *testing.T, served *string, hits *int, mu *sync.Mutex) (*httptest.Server, string) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		*hits++
+		if r.URL.Path != "/.well-known/s3s2.asc" || *served == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.ServeFile(w, r, *served)
+	}))
+	ca_bundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(ca_bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644))
+	return server, ca_bundle
+}
+
+// a published key is only encrypted to when it matches its pin, and is cached between shares
+func TestShareToPinnedKeyUrl(t *testing.T) {
+	rt := new_round_trip(t)
+	// after building, so the go build cache is left alone
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	served, hits := rt.pub_key, 0
+	var mu sync.Mutex
+	server, ca_bundle := key_server(t, &served, &hits, &mu)
+	defer server.Close()
+
+	local_key := rt.pub_key
+	rt.pub_key = server.URL + "/.well-known/s3s2.asc"
+
+	out, err := rt.try_share("--ca-bundle", ca_bundle)
+	assert.Error(t, err)
+	assert.Contains(t, out, "--receiver-key-fingerprint")
+
+	tampered, _ := sender_keys(t, "tampered")
+	served = tampered
+	out, err = rt.try_share("--ca-bundle", ca_bundle, "--receiver-key-fingerprint", test_key_fingerprint)
+	assert.Error(t, err)
+	assert.Contains(t, out, "does not match any pinned fingerprint")
+
+	served = local_key
+	rt.share("--ca-bundle", ca_bundle, "--receiver-key-fingerprint", test_key_fingerprint)
+	assert.Equal(t, 2, hits)
+
+	// the cached key is used until it expires
+	rt.share("--ca-bundle", ca_bundle, "--receiver-key-fingerprint", test_key_fingerprint)
+	assert.Equal(t, 2, hits)
+
+	// and when the url can't be reached
+	served = ""
+	rt.share("--ca-bundle", ca_bundle, "--receiver-key-fingerprint", test_key_fingerprint, "--key-cache-ttl", "0s")
+	assert.Equal(t, 3, hits)
+
+	// as long as it isn't older than --key-cache-max-stale
+	cached, _ := filepath.Glob(filepath.Join(os.Getenv("XDG_CACHE_HOME"), "s3s2", "keys", "*.asc"))
+	require.Len(t, cached, 1)
+	fetched := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(cached[0], fetched, fetched))
+	out, err = rt.try_share("--ca-bundle", ca_bundle, "--receiver-key-fingerprint", test_key_fingerprint, "--key-cache-ttl", "0s", "--key-cache-max-stale", "1h")
+	assert.Error(t, err)
+	assert.Contains(t, out, "older than --key-cache-max-stale allows")
+	rt.share("--ca-bundle", ca_bundle, "--receiver-key-fingerprint", test_key_fingerprint, "--key-cache-ttl", "0s", "--key-cache-max-stale", "3h")
+
+	// but never in place of a key that fails its pin
+	served = tampered
+	out, err = rt.try_share("--ca-bundle", ca_bundle, "--receiver-key-fingerprint", test_key_fingerprint, "--key-cache-ttl", "0s")
+	assert.Error(t, err)
+	assert.Contains(t, out, "does not match any pinned fingerprint")
+
+	rt.pub_key = local_key
+	rt.decrypt()
+	rt.assert_decrypted()
+}
@@ -0,0 +1,76 @@
+package main_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	encrypt "github.com/tempuslabs/s3s2/encrypt"
+)
+
+// keys exported by gpg carry a signing primary key and a separate encryption subkey, the private keyring is binary
+func gpg_keyring(t *testing.T) (string, string) {
+	pub, _ := filepath.Abs("resources/keyring.pubkey")
+	priv, _ := filepath.Abs("resources/keyring.privkey")
+	return pub, priv
+}
+
+func TestShareToGpgKeyring(t *testing.T) {
+	rt := new_round_trip(t)
+	rt.pub_key, rt.priv_key = gpg_keyring(t)
+
+	rt.share()
+	rt.decrypt()
+	rt.assert_decrypted()
+
+	rt.decrypted = t.TempDir()
+	rt.decrypt("--streaming")
+	rt.assert_decrypted()
+}
+
+// genkey keys and gpg keyrings can be mixed, as recipients and as senders
+func TestSignedShareWithGpgKeyring(t *testing.T) {
+	rt := new_round_trip(t)
+	keyring_pub, keyring_priv := gpg_keyring(t)
+
+	rt.pub_key = rt.pub_key + "," + keyring_pub
+	rt.share("--sender-private-key", keyring_priv)
+
+	rt.decrypt("--strict", "--trusted-senders", keyring_pub)
+	rt.assert_decrypted()
+}
+
+func TestShareRefusesRevokedAndExpiredKeys(t *testing.T) {
+	rt := new_round_trip(t)
+
+	for key, reason := range map[string]string{
+		"resources/revoked.pubkey": "has been revoked",
+		"resources/expired.pubkey": "has expired",
+	} {
+		rt.pub_key, _ = filepath.Abs(key)
+		out, err := rt.try_share()
+		assert.Error(t, err)
+		assert.Contains(t, out, reason)
+	}
+}
+
+// genkey's ECC keys work as receiver and sender keys alike
+func TestShareWithECCKeys(t *testing.T) {
+	for _, algo := range []string{encrypt.AlgoCurve25519, encrypt.AlgoEd25519} {
+		t.Run(algo, func(t *testing.T) {
+			rt := new_round_trip(t)
+			dir := t.TempDir()
+			encrypt.GenerateKeys(dir, "ecc", algo, 0, nil)
+			rt.pub_key = filepath.Join(dir, "ecc.pubkey")
+			rt.priv_key = filepath.Join(dir, "ecc.privkey")
+
+			rt.share("--sender-private-key", rt.priv_key)
+			rt.decrypt("--strict", "--trusted-senders", rt.pub_key)
+			rt.assert_decrypted()
+
+			rt.decrypted = t.TempDir()
+			rt.decrypt("--streaming", "--strict", "--trusted-senders", rt.pub_key)
+			rt.assert_decrypted()
+		})
+	}
+}
@@ -0,0 +1,346 @@
+package main_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compress "github.com/tempuslabs/s3s2/compress"
+	encrypt "github.com/tempuslabs/s3s2/encrypt"
+	manifest "github.com/tempuslabs/s3s2/manifest"
+	options "github.com/tempuslabs/s3s2/options"
+	storage "github.com/tempuslabs/s3s2/storage"
+	utils "github.com/tempuslabs/s3s2/utils"
+)
+
+// build the s3s2 binary so commands run exactly as they would for a partner
+func build_s3s2(t *testing.T) string {
+	binary := filepath.Join(t.TempDir(), "s3s2")
+	out, err := exec.Command("go", "build", "-o", binary, "..").CombinedOutput()
+	require.NoError(t, err, string(out))
+	return binary
+}
+
+func run_s3s2(t *testing.T, binary string, args ...string) {
+	out, err := exec.Command(binary, args...).CombinedOutput()
+	require.NoError(t, err, string(out))
+}
+
+func local_backend(t *testing.T, root string) storage.Backend {
+	backend, err := storage.NewBackend(options.Options{Bucket: "file://" + filepath.ToSlash(root)})
+	require.NoError(t, err)
+	return backend
+}
+
+func TestLocalBackendPutGetListDelete(t *testing.T) {
+	assert := assert.New(t)
+	backend := local_backend(t, t.TempDir())
+
+	assert.NoError(backend.PutStream("ORG/batch/a.txt.zip.gpg", strings.NewReader("some data")))
+	assert.NoError(storage.UploadLambdaTrigger(backend, "org", "batch"))
+
+	info, err := backend.Head("ORG/batch/a.txt.zip.gpg")
+	assert.NoError(err)
+	assert.Equal(int64(9), info.Size)
+
+	objects, err := backend.List("ORG/batch/")
+	assert.NoError(err)
+	assert.Len(objects, 2)
+
+	target := filepath.Join(t.TempDir(), "a.txt.zip.gpg")
+	_, err = storage.DownloadFile(backend, "ORG/batch/a.txt.zip.gpg", target)
+	assert.NoError(err)
+	data, _ := os.ReadFile(target)
+	assert.Equal("some data", string(data))
+
+	assert.NoError(backend.Delete("ORG/batch/a.txt.zip.gpg"))
+	_, err = backend.Head("ORG/batch/a.txt.zip.gpg")
+	assert.True(os.IsNotExist(err))
+
+	_, err = backend.Get("../outside")
+	assert.Error(err)
+}
+
+// a source directory, a local destination and a download directory to share and decrypt between
+type round_trip struct {
+	t           *testing.T
+	binary      string
+	source      string
+	destination string
+	decrypted   string
+	bucket      string
+	pub_key     string
+	priv_key    string
+}
+
+func new_round_trip(t *testing.T) *round_trip {
+	rt := &round_trip{
+		t:           t,
+		binary:      build_s3s2(t),
+		source:      t.TempDir(),
+		destination: t.TempDir(),
+		decrypted:   t.TempDir(),
+	}
+	rt.bucket = "file://" + filepath.ToSlash(rt.destination)
+	rt.pub_key, _ = filepath.Abs("resources/testkey.pubkey")
+	rt.priv_key, _ = filepath.Abs("resources/testkey.privkey")
+
+	require.NoError(t, os.MkdirAll(filepath.Join(rt.source, "nested"), os.ModePerm))
+	require.NoError(t, writeToFile(filepath.Join(rt.source, "a.txt"), "top level file"))
+	require.NoError(t, writeToFile(filepath.Join(rt.source, "nested", "b.txt"), "nested file"))
+	return rt
+}
+
+func (rt *round_trip) share(args ...string) {
+	out, err := rt.try_share(args...)
+	require.NoError(rt.t, err, out)
+}
+
+// runs share and returns its output, for runs that are expected to fail
+func (rt *round_trip) try_share(args ...string) (string, error) {
+	out, err := exec.Command(rt.binary, append([]string{"share",
+		"--bucket", rt.bucket,
+		"--region", "us-east-1",
+		"--directory", rt.source,
+		"--org", "TestOrg",
+		"--prefix", "clinical",
+		"--receiver-public-key", rt.pub_key,
+		"--delete-on-completion=false"}, args...)...).CombinedOutput()
+	return string(out), err
+}
+
+// the key of the most recently shared manifest
+func (rt *round_trip) manifest_key() string {
+	objects, err := local_backend(rt.t, rt.destination).List("TESTORG/")
+	require.NoError(rt.t, err)
+
+	var manifest_key string
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.Key, "s3s2_manifest.json") && obj.Key > manifest_key {
+			manifest_key = obj.Key
+		}
+	}
+	require.NotEmpty(rt.t, manifest_key)
+	return manifest_key
+}
+
+func (rt *round_trip) decrypt(args ...string) {
+	out, err := rt.try_decrypt(args...)
+	require.NoError(rt.t, err, out)
+}
+
+// runs decrypt and returns its output, for runs that are expected to fail
+func (rt *round_trip) try_decrypt(args ...string) (string, error) {
+	out, err := rt.decrypt_command(args...).CombinedOutput()
+	return string(out), err
+}
+
+func (rt *round_trip) decrypt_command(args ...string) *exec.Cmd {
+	return exec.Command(rt.binary, append([]string{"decrypt",
+		"--bucket", rt.bucket,
+		"--region", "us-east-1",
+		"--file", rt.manifest_key(),
+		"--directory", rt.decrypted,
+		"--my-public-key", rt.pub_key,
+		"--my-private-key", rt.priv_key}, args...)...)
+}
+
+func (rt *round_trip) assert_decrypted() {
+	top, _ := os.ReadFile(filepath.Join(rt.decrypted, "decrypted", "a.txt"))
+	nested, _ := os.ReadFile(filepath.Join(rt.decrypted, "decrypted", "nested", "b.txt"))
+	assert.Equal(rt.t, "top level file", string(top))
+	assert.Equal(rt.t, "nested file", string(nested))
+}
+
+// share a directory to a local destination and decrypt it back again, no cloud involved
+func TestLocalShareDecryptRoundTrip(t *testing.T) {
+	rt := new_round_trip(t)
+	rt.share()
+	rt.decrypt()
+	rt.assert_decrypted()
+}
+
+// a batch shared to several receivers can be decrypted by each of them alone
+func TestShareToMultipleRecipients(t *testing.T) {
+	rt := new_round_trip(t)
+	primary_pub, primary_priv := rt.pub_key, rt.priv_key
+	escrow_pub, escrow_priv := sender_keys(t, "escrow")
+
+	rt.pub_key = primary_pub + "," + escrow_pub
+	rt.share()
+
+	rt.pub_key, rt.priv_key = escrow_pub, escrow_priv
+	rt.decrypt()
+	rt.assert_decrypted()
+
+	rt.pub_key, rt.priv_key = primary_pub, primary_priv
+	rt.decrypted = t.TempDir()
+	rt.decrypt("--streaming")
+	rt.assert_decrypted()
+}
+
+// binary objects carry no armor, decrypt follows the manifest or detects the format for manifests that do not record it
+func TestBinaryShareRoundTrip(t *testing.T) {
+	rt := new_round_trip(t)
+	rt.share("--binary", "--streaming")
+
+	backend := local_backend(t, rt.destination)
+	target := filepath.Join(t.TempDir(), "s3s2_manifest.json")
+	_, err := storage.DownloadFile(backend, rt.manifest_key(), target)
+	require.NoError(t, err)
+	m := manifest.ReadManifest(target)
+	assert.Equal(t, encrypt.FormatBinary, m.Format)
+
+	object, err := os.ReadFile(filepath.Join(rt.destination, filepath.Dir(rt.manifest_key()), "a.txt.zip.gpg"))
+	require.NoError(t, err)
+	assert.False(t, strings.HasPrefix(string(object), "-----BEGIN"))
+
+	rt.decrypt()
+	rt.assert_decrypted()
+
+	m.Format = ""
+	data, err := m.Marshal()
+	require.NoError(t, err)
+	require.NoError(t, backend.PutStream(rt.manifest_key(), bytes.NewReader(data)))
+
+	rt.decrypted = t.TempDir()
+	rt.decrypt("--streaming")
+	rt.assert_decrypted()
+}
+
+// each file records the codec it was compressed with, files that are already compressed are left as they are
+func TestZstdShareRoundTrip(t *testing.T) {
+	rt := new_round_trip(t)
+	png := []byte("\x89PNG\r\n\x1a\n" + strings.Repeat("not really an image", 100))
+	require.NoError(t, os.WriteFile(filepath.Join(rt.source, "image.png"), png, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(rt.source, "scan"), png, 0644))
+	dicom := append(make([]byte, 128), []byte("DICM"+strings.Repeat("not really a study", 100))...)
+	require.NoError(t, os.WriteFile(filepath.Join(rt.source, "study"), dicom, 0644))
+	rt.share("--compression", "zstd")
+
+	target := filepath.Join(t.TempDir(), "s3s2_manifest.json")
+	_, err := storage.DownloadFile(local_backend(t, rt.destination), rt.manifest_key(), target)
+	require.NoError(t, err)
+	codecs := make(map[string]string)
+	for _, f := range manifest.ReadManifest(target).Files {
+		codecs[f.Name] = f.Compression
+	}
+	assert.Equal(t, map[string]string{
+		"a.txt":        compress.Zstd,
+		"nested/b.txt": compress.Zstd,
+		"image.png":    compress.None,
+		"scan":         compress.None,
+		"study":        compress.None,
+	}, codecs)
+
+	rt.decrypt()
+	rt.assert_decrypted()
+	decrypted, _ := os.ReadFile(filepath.Join(rt.decrypted, "decrypted", "scan"))
+	assert.Equal(t, png, decrypted)
+
+	rt.decrypted = t.TempDir()
+	rt.decrypt("--streaming")
+	rt.assert_decrypted()
+}
+
+// a streamed share leaves nothing behind in the source directory
+func TestStreamingShareRoundTrip(t *testing.T) {
+	rt := new_round_trip(t)
+	rt.share("--streaming")
+
+	var written []string
+	filepath.Walk(rt.source, func(file_path string, info os.FileInfo, err error) error {
+		if !info.IsDir() {
+			written = append(written, utils.GetRelativePath(file_path, rt.source))
+		}
+		return nil
+	})
+	assert.ElementsMatch(t, []string{"a.txt", "nested/b.txt"}, written)
+
+	rt.decrypt()
+	rt.assert_decrypted()
+}
+
+// a streamed decrypt writes only the decrypted files
+func TestStreamingDecryptRoundTrip(t *testing.T) {
+	rt := new_round_trip(t)
+	rt.share()
+	rt.decrypt("--streaming")
+	rt.assert_decrypted()
+
+	var written []string
+	filepath.Walk(rt.decrypted, func(file_path string, info os.FileInfo, err error) error {
+		if !info.IsDir() {
+			written = append(written, utils.GetRelativePath(file_path, rt.decrypted))
+		}
+		return nil
+	})
+	folder := read_manifest(rt).Folder
+	assert.ElementsMatch(t, []string{
+		"s3s2_manifest.json",
+		"s3s2_progress_" + folder + ".jsonl",
+		"s3s2_integrity_" + folder + ".json",
+		"decrypted/a.txt",
+		"decrypted/nested/b.txt",
+	}, written)
+}
+
+// a rerun only decrypts files that are missing or changed since the last run
+func TestDecryptSkipExisting(t *testing.T) {
+	rt := new_round_trip(t)
+	rt.share()
+	rt.decrypt()
+
+	progress, _ := filepath.Glob(filepath.Join(rt.decrypted, "s3s2_progress_*.jsonl"))
+	require.Len(t, progress, 1)
+
+	// a.txt cannot be downloaded again, so the rerun only succeeds if it is skipped
+	backend := local_backend(t, rt.destination)
+	batch := filepath.Dir(rt.manifest_key())
+	require.NoError(t, backend.Delete(batch+"/a.txt.zip.gpg"))
+	require.NoError(t, writeToFile(filepath.Join(rt.decrypted, "decrypted", "nested", "b.txt"), "trunc"))
+
+	rt.decrypt("--skip-existing")
+	rt.assert_decrypted()
+}
+
+// sharing from a csv index streams every file under a tight memory ceiling
+func TestShareFromListRoundTrip(t *testing.T) {
+	rt := new_round_trip(t)
+
+	index := filepath.Join(t.TempDir(), "index.csv")
+	rows := "file_key,file_path\n" +
+		"a," + filepath.Join(rt.source, "a.txt") + "\n" +
+		"b," + filepath.Join(rt.source, "nested", "b.txt") + "\n"
+	require.NoError(t, writeToFile(index, rows))
+
+	run_s3s2(t, rt.binary, "share",
+		"--bucket", rt.bucket,
+		"--region", "us-east-1",
+		"--share-from-list", index,
+		"--memory-limit", "16",
+		"--parallelism", "4",
+		"--org", "TestOrg",
+		"--prefix", "clinical",
+		"--receiver-public-key", rt.pub_key,
+		"--lambda-trigger=false",
+		"--delete-on-completion=false")
+
+	rt.decrypt()
+
+	// shared files are flattened into a folder named for the day they were shared
+	top, _ := filepath.Glob(filepath.Join(rt.decrypted, "decrypted", "*", "a.txt"))
+	nested, _ := filepath.Glob(filepath.Join(rt.decrypted, "decrypted", "*", "b.txt"))
+	require.Len(t, top, 1)
+	require.Len(t, nested, 1)
+
+	data, _ := os.ReadFile(top[0])
+	assert.Equal(t, "top level file", string(data))
+	data, _ = os.ReadFile(nested[0])
+	assert.Equal(t, "nested file", string(data))
+}
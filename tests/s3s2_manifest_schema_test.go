@@ -0,0 +1,98 @@
+package main_test
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	compress "github.com/tempuslabs/s3s2/compress"
+	file "github.com/tempuslabs/s3s2/file"
+	manifest "github.com/tempuslabs/s3s2/manifest"
+	options "github.com/tempuslabs/s3s2/options"
+)
+
+// manifests written before the schema version was recorded list little more than file names
+func TestParseManifestUpgradesV1(t *testing.T) {
+	m, err := manifest.ParseManifest([]byte(`{
+		"Organization": "TESTORG",
+		"Folder": "clinical_s3s2_20200101000000_0",
+		"Files": [{"Name": "a.txt"}, {"Name": "nested\\b.txt"}]
+	}`))
+	require.NoError(t, err)
+
+	assert.Equal(t, manifest.SchemaVersion, m.SchemaVersion)
+	assert.Equal(t, "s3s2_manifest.json", m.Name)
+	assert.Empty(t, m.Format)
+	require.Len(t, m.Files, 2)
+	assert.Equal(t, "nested/b.txt", m.Files[1].Name)
+	for _, f := range m.Files {
+		assert.Equal(t, compress.Gzip, f.Compression)
+		assert.False(t, f.Stamped())
+	}
+}
+
+func TestParseManifestRejectsInvalid(t *testing.T) {
+	for name, test := range map[string]struct{ manifest, err string }{
+		"not json":         {`{"Folder": `, "invalid manifest"},
+		"wrong type":       {`{"SchemaVersion": 2, "Files": "a.txt"}`, "invalid manifest"},
+		"newer version":    {`{"SchemaVersion": 4, "Name": "s3s2_manifest.json"}`, "newer than the 3 this s3s2 supports"},
+		"unknown format":   {`{"SchemaVersion": 2, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Format": "rot13"}`, "unknown format 'rot13'"},
+		"climbs out":       {`{"Organization": "TESTORG", "Folder": "f", "Files": [{"Name": "../../.bashrc"}]}`, "not relative to the batch folder"},
+		"absolute":         {`{"Organization": "TESTORG", "Folder": "f", "Files": [{"Name": "/etc/cron.d/job"}]}`, "not relative to the batch folder"},
+		"key is a path":    {`{"Organization": "TESTORG", "Folder": "f", "Files": [{"Name": "a.txt", "Key": "../a"}]}`, "invalid key '../a'"},
+		"stub lists files": {`{"Organization": "TESTORG", "Folder": "f", "Sealed": {"Name": "s3s2_manifest.json.gpg"}, "Files": [{"Name": "a.txt"}]}`, "stub of a sealed manifest lists files"},
+		"no data key":      {`{"SchemaVersion": 2, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Format": "kms", "Files": [{"Name": "a.txt"}]}`, "file 'a.txt' has no data key"},
+		"v2 key":           {`{"SchemaVersion": 2, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Files": [{"Name": "a.txt", "Key": "0a1b"}]}`, "needs schema version 3"},
+		"stray data key":   {`{"SchemaVersion": 3, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Format": "armored", "Sealed": {"Name": "s3s2_manifest.json.gpg", "DataKey": "d3JhcHBlZA=="}}`, "the sealed manifest has a data key"},
+		"v2 sealed":        {`{"SchemaVersion": 2, "Name": "s3s2_manifest.json", "Organization": "TESTORG", "Folder": "f", "Sealed": {"Name": "s3s2_manifest.json.gpg"}}`, "needs schema version 3"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := manifest.ParseManifest([]byte(test.manifest))
+			assert.ErrorContains(t, err, test.err)
+		})
+	}
+
+	// every problem is reported, not just the first
+	_, err := manifest.ParseManifest([]byte(`{"Files": [{"Name": ""}, {"Name": "a.txt", "Size": -1}]}`))
+	assert.ErrorContains(t, err, "no batch folder")
+	assert.ErrorContains(t, err, "no organization")
+	assert.ErrorContains(t, err, "file 0 has no name")
+	assert.ErrorContains(t, err, "file 'a.txt' has a negative size")
+}
+
+// what share writes reads back unchanged, and the published schema describes the same version
+func TestManifestSchemaVersion(t *testing.T) {
+	dir := t.TempDir()
+	built, err := manifest.BuildManifest([]file.File{{Name: "a.txt", Compression: compress.Zstd}}, "clinical_s3s2_20200101000000_0", nil,
+		options.Options{Org: "TESTORG", Directory: dir})
+	require.NoError(t, err)
+	data, err := built.Marshal()
+	require.NoError(t, err)
+
+	m, err := manifest.ParseManifest(data)
+	require.NoError(t, err)
+	assert.Equal(t, manifest.SchemaVersion, m.SchemaVersion)
+	assert.Equal(t, built.Files, m.Files)
+
+	var schema struct {
+		Properties struct {
+			SchemaVersion struct{ Enum []int }
+		}
+	}
+	data, err = os.ReadFile("../manifest/s3s2_manifest.schema.json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &schema))
+	assert.Equal(t, []int{manifest.SchemaVersion, manifest.SealedSchemaVersion}, schema.Properties.SchemaVersion.Enum)
+}
+
+// only batches shared with opaque names need the newer version, every other batch stays readable by older clients
+func TestShareWritesSealedSchemaVersionOnlyForOpaqueNames(t *testing.T) {
+	rt := new_round_trip(t)
+	rt.share()
+	assert.Equal(t, 2, read_manifest(rt).SchemaVersion)
+
+	rt.share("--opaque-names")
+	assert.Equal(t, 3, read_manifest(rt).SchemaVersion)
+}
@@ -0,0 +1,88 @@
+package main_test
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nothing in the bucket names a file, the encrypted manifest restores the original paths
+func TestOpaqueNamesRoundTrip(t *testing.T) {
+	for name, args := range map[string][]string{
+		"files":     {"--opaque-names"},
+		"streaming": {"--opaque-names", "--streaming", "--binary"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			rt := new_round_trip(t)
+			rt.share(args...)
+
+			objects, err := local_backend(t, rt.destination).List("TESTORG/")
+			require.NoError(t, err)
+			// both files, the stub, the encrypted manifest and the lambda trigger
+			require.Len(t, objects, 5)
+			for _, obj := range objects {
+				assert.NotContains(t, obj.Key, "a.txt")
+				assert.NotContains(t, obj.Key, "nested")
+			}
+
+			stub := read_manifest(rt)
+			require.NotNil(t, stub.Sealed)
+			assert.Equal(t, "s3s2_manifest.json.gpg", stub.Sealed.Name)
+			assert.Empty(t, stub.Files)
+
+			rt.decrypt()
+			rt.assert_decrypted()
+		})
+	}
+}
+
+// the encrypted manifest is re-keyed with the objects, and is what decrypt checks the key against once the signed stub is out of date
+func TestOpaqueNamesRekey(t *testing.T) {
+	rt := new_round_trip(t)
+	sender_pub, sender_priv := sender_keys(t, "sender")
+	new_pub, new_priv := sender_keys(t, "rotated")
+
+	rt.share("--opaque-names", "--sender-private-key", sender_priv)
+	rt.rekey("--new-public-key", new_pub)
+
+	out, err := rt.try_decrypt()
+	assert.Error(t, err, out)
+
+	rt.pub_key, rt.priv_key = new_pub, new_priv
+	rt.decrypted = t.TempDir()
+	out, err = rt.try_decrypt("--strict", "--trusted-senders", sender_pub)
+	require.NoError(t, err, out)
+	assert.Contains(t, out, "re-keyed after it was shared")
+	rt.assert_decrypted()
+}
+
+// the encrypted manifest of an envelope batch is sealed under a data key of its own, only decrypt can unwrap it
+func TestOpaqueNamesEnvelope(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "s3s2")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "s3s2")
+
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	server := fake_kms(calls, &mu)
+	defer server.Close()
+
+	rt := new_round_trip(t)
+	_, sender_priv := sender_keys(t, "sender")
+	new_pub, _ := sender_keys(t, "rotated")
+	rt.pub_key, rt.priv_key = "", ""
+	rt.share("--opaque-names", "--kms-key-id", "alias/s3s2", "--kms-endpoint-url", server.URL)
+
+	stub := read_manifest(rt)
+	require.NotNil(t, stub.Sealed)
+	assert.NotEmpty(t, stub.Sealed.DataKey)
+
+	rt.decrypt("--kms-endpoint-url", server.URL)
+	rt.assert_decrypted()
+
+	rt.priv_key = sender_priv
+	out, err := rt.try_rekey("--new-public-key", new_pub)
+	assert.Error(t, err)
+	assert.Contains(t, out, "rotate the KMS or transit key instead")
+}
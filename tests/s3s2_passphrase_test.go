@@ -0,0 +1,76 @@
+package main_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// the passphrase of the protected gpg keyring in resources
+const test_passphrase = "correct horse"
+
+func protected_keyring(t *testing.T) (string, string) {
+	pub, _ := filepath.Abs("resources/protected.pubkey")
+	priv, _ := filepath.Abs("resources/protected.privkey")
+	return pub, priv
+}
+
+func TestDecryptWithProtectedKeyring(t *testing.T) {
+	rt := new_round_trip(t)
+	rt.pub_key, rt.priv_key = protected_keyring(t)
+	rt.share()
+
+	t.Setenv("S3S2_TEST_PASSPHRASE", test_passphrase)
+	rt.decrypt("--passphrase-env", "S3S2_TEST_PASSPHRASE")
+	rt.assert_decrypted()
+
+	// the passphrase can also be piped in, like gpg's --passphrase-fd
+	rt.decrypted = t.TempDir()
+	cmd := rt.decrypt_command("--passphrase-fd", "0")
+	cmd.Stdin = strings.NewReader(test_passphrase + "\n")
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	rt.assert_decrypted()
+}
+
+func TestDecryptRefusesProtectedKeyWithoutPassphrase(t *testing.T) {
+	rt := new_round_trip(t)
+	rt.pub_key, rt.priv_key = protected_keyring(t)
+	rt.share()
+
+	// stdin is not a terminal, so there is nothing to prompt on
+	out, err := rt.try_decrypt()
+	assert.Error(t, err)
+	assert.Contains(t, out, "private key is passphrase-protected")
+
+	t.Setenv("S3S2_TEST_PASSPHRASE", "wrong")
+	out, err = rt.try_decrypt("--passphrase-env", "S3S2_TEST_PASSPHRASE")
+	assert.Error(t, err)
+	assert.Contains(t, out, "is the passphrase correct")
+}
+
+func TestGenkeyProtectsPrivateKey(t *testing.T) {
+	rt := new_round_trip(t)
+	dir := t.TempDir()
+
+	cmd := exec.Command(rt.binary, "genkey", "--keydir", dir, "--keyprefix", "protected", "--passphrase-env", "S3S2_TEST_PASSPHRASE")
+	cmd.Env = append(os.Environ(), "S3S2_TEST_PASSPHRASE="+test_passphrase)
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	rt.pub_key = filepath.Join(dir, "protected.pubkey")
+	rt.priv_key = filepath.Join(dir, "protected.privkey")
+	rt.share()
+
+	_, err = rt.try_decrypt()
+	assert.Error(t, err)
+
+	t.Setenv("S3S2_TEST_PASSPHRASE", test_passphrase)
+	rt.decrypt("--passphrase-env", "S3S2_TEST_PASSPHRASE")
+	rt.assert_decrypted()
+}
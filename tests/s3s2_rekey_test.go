@@ -0,0 +1,76 @@
+package main_test
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runs rekey on the most recently shared manifest, from the round trip's private key
+func (rt *round_trip) rekey(args ...string) {
+	out, err := rt.try_rekey(args...)
+	require.NoError(rt.t, err, out)
+}
+
+// runs rekey and returns its output, for runs that are expected to fail
+func (rt *round_trip) try_rekey(args ...string) (string, error) {
+	out, err := exec.Command(rt.binary, append([]string{"rekey",
+		"--bucket", rt.bucket,
+		"--region", "us-east-1",
+		"--manifest", rt.manifest_key(),
+		"--old-private-key", rt.priv_key}, args...)...).CombinedOutput()
+	return string(out), err
+}
+
+// a batch re-keyed in place is only readable by the new key, and the sender's signatures still verify
+func TestRekeyInPlace(t *testing.T) {
+	rt := new_round_trip(t)
+	sender_pub, sender_priv := sender_keys(t, "sender")
+	new_pub, new_priv := sender_keys(t, "rotated")
+
+	rt.share("--sender-private-key", sender_priv)
+	rt.rekey("--new-public-key", new_pub)
+
+	out, err := rt.try_decrypt()
+	assert.Error(t, err, out)
+
+	// running it again finds every object already re-keyed
+	rt.rekey("--new-public-key", new_pub)
+
+	rt.pub_key, rt.priv_key = new_pub, new_priv
+	rt.decrypted = t.TempDir()
+	rt.decrypt("--strict", "--trusted-senders", sender_pub)
+	rt.assert_decrypted()
+}
+
+// re-keying to a new batch folder leaves the original alone and keeps the other receivers of the batch
+func TestRekeyToNewBatchFolder(t *testing.T) {
+	rt := new_round_trip(t)
+	old_pub, old_priv := rt.pub_key, rt.priv_key
+	escrow_pub, escrow_priv := sender_keys(t, "escrow")
+	new_pub, new_priv := sender_keys(t, "rotated")
+
+	rt.pub_key = old_pub + "," + escrow_pub
+	rt.share("--binary")
+	original := rt.manifest_key()
+
+	// sorts after the timestamped batch folder, so the round trip decrypts the re-keyed manifest from here on
+	rt.rekey("--new-public-key", new_pub, "--batch-folder", "clinical_s3s2_rekeyed")
+	require.Equal(t, "TESTORG/clinical_s3s2_rekeyed/s3s2_manifest.json", rt.manifest_key())
+
+	rt.pub_key, rt.priv_key = new_pub, new_priv
+	rt.decrypt("--streaming")
+	rt.assert_decrypted()
+
+	rt.pub_key, rt.priv_key = escrow_pub, escrow_priv
+	rt.decrypted = t.TempDir()
+	rt.decrypt()
+	rt.assert_decrypted()
+
+	rt.pub_key, rt.priv_key = old_pub, old_priv
+	rt.decrypted = t.TempDir()
+	rt.decrypt("--file", original)
+	rt.assert_decrypted()
+}
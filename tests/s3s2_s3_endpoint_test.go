@@ -0,0 +1,63 @@
+package main_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	options "github.com/tempuslabs/s3s2/options"
+	storage "github.com/tempuslabs/s3s2/storage"
+)
+
+// a stand-in for MinIO that records the objects written to it
+func fake_object_store(objects map[string]string, mu *sync.Mutex) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			objects[r.URL.Path] = string(body)
+			w.Header().Set("ETag", `"etag"`)
+		case http.MethodGet:
+			body, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			io.WriteString(w, body)
+		}
+	}))
+}
+
+func TestS3CompatibleEndpointPathStyle(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("AWS_ACCESS_KEY_ID", "minioadmin")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "minioadmin")
+
+	var mu sync.Mutex
+	objects := make(map[string]string)
+	server := fake_object_store(objects, &mu)
+	defer server.Close()
+
+	backend, err := storage.NewBackend(options.Options{
+		Bucket:      "s3://partner-bucket/incoming",
+		Region:      "us-east-1",
+		EndpointUrl: server.URL,
+		PathStyle:   true,
+	})
+	assert.NoError(err)
+
+	assert.NoError(backend.PutStream("ORG/batch/a.txt.zip.gpg", strings.NewReader("encrypted")))
+	assert.Equal("encrypted", objects["/partner-bucket/incoming/ORG/batch/a.txt.zip.gpg"])
+
+	body, err := backend.Get("ORG/batch/a.txt.zip.gpg")
+	assert.NoError(err)
+	data, _ := io.ReadAll(body)
+	body.Close()
+	assert.Equal("encrypted", string(data))
+}
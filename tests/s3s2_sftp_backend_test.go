@@ -0,0 +1,152 @@
+package main_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	options "github.com/tempuslabs/s3s2/options"
+	storage "github.com/tempuslabs/s3s2/storage"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func new_ssh_signer(t *testing.T) (ssh.Signer, ed25519.PrivateKey) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	return signer, priv
+}
+
+// an in-process sftp server serving the local filesystem, accepting only the given client key
+func start_sftp_server(t *testing.T, host_key ssh.Signer, client_key ssh.PublicKey) string {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(client_key.Marshal()) {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(host_key)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for new_channel := range channels {
+					channel, channel_requests, err := new_channel.Accept()
+					if err != nil {
+						continue
+					}
+					go func() {
+						for req := range channel_requests {
+							req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+						}
+					}()
+					go func() {
+						server, err := sftp.NewServer(channel)
+						if err == nil {
+							server.Serve()
+						}
+						channel.Close()
+					}()
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func sftp_options(t *testing.T, address string, root string, host_key ssh.PublicKey, client_key ed25519.PrivateKey) options.Options {
+	dir := t.TempDir()
+
+	block, err := ssh.MarshalPrivateKey(client_key, "")
+	require.NoError(t, err)
+	key_path := filepath.Join(dir, "id_ed25519")
+	require.NoError(t, os.WriteFile(key_path, pem.EncodeToMemory(block), 0600))
+
+	known_hosts := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(address)}, host_key)
+	require.NoError(t, os.WriteFile(known_hosts, []byte(line+"\n"), 0600))
+
+	return options.Options{
+		Bucket:         "sftp://s3s2@" + address + filepath.ToSlash(root),
+		SftpKey:        key_path,
+		SftpKnownHosts: known_hosts,
+	}
+}
+
+func TestSftpBackendPutGetListDelete(t *testing.T) {
+	assert := assert.New(t)
+
+	host_signer, _ := new_ssh_signer(t)
+	client_signer, client_key := new_ssh_signer(t)
+	address := start_sftp_server(t, host_signer, client_signer.PublicKey())
+	root := t.TempDir()
+
+	backend, err := storage.NewBackend(sftp_options(t, address, root, host_signer.PublicKey(), client_key))
+	require.NoError(t, err)
+
+	assert.NoError(backend.PutStream("ORG/batch/a.txt.zip.gpg", strings.NewReader("encrypted")))
+	assert.NoError(backend.PutStream("ORG/batch/a.txt.zip.gpg", strings.NewReader("encrypted again")))
+	assert.NoError(storage.UploadLambdaTrigger(backend, "org", "batch"))
+
+	data, err := os.ReadFile(filepath.Join(root, "ORG", "batch", "a.txt.zip.gpg"))
+	assert.NoError(err)
+	assert.Equal("encrypted again", string(data))
+
+	info, err := backend.Head("ORG/batch/a.txt.zip.gpg")
+	assert.NoError(err)
+	assert.Equal(int64(15), info.Size)
+
+	objects, err := backend.List("ORG/")
+	assert.NoError(err)
+	assert.Len(objects, 2)
+
+	body, err := backend.Get("ORG/batch/a.txt.zip.gpg")
+	assert.NoError(err)
+	data, _ = io.ReadAll(body)
+	body.Close()
+	assert.Equal("encrypted again", string(data))
+
+	assert.NoError(backend.Delete("ORG/batch/a.txt.zip.gpg"))
+	_, err = backend.Head("ORG/batch/a.txt.zip.gpg")
+	assert.Error(err)
+}
+
+// a server presenting a host key that is not in known_hosts must be refused
+func TestSftpBackendRejectsUnknownHost(t *testing.T) {
+	host_signer, _ := new_ssh_signer(t)
+	other_signer, _ := new_ssh_signer(t)
+	client_signer, client_key := new_ssh_signer(t)
+	address := start_sftp_server(t, host_signer, client_signer.PublicKey())
+
+	backend, err := storage.NewBackend(sftp_options(t, address, t.TempDir(), other_signer.PublicKey(), client_key))
+	require.NoError(t, err)
+
+	err = backend.PutStream("ORG/batch/a.txt.zip.gpg", strings.NewReader("encrypted"))
+	assert.ErrorContains(t, err, "key mismatch")
+}
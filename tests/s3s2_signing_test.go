@@ -0,0 +1,86 @@
+package main_test
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	encrypt "github.com/tempuslabs/s3s2/encrypt"
+	manifest "github.com/tempuslabs/s3s2/manifest"
+	options "github.com/tempuslabs/s3s2/options"
+)
+
+// a sender key pair in the same format genkey writes
+func sender_keys(t *testing.T, name string) (string, string) {
+	dir := t.TempDir()
+	encrypt.GenerateKeys(dir, name, encrypt.AlgoRSA, 2048, nil)
+	return filepath.Join(dir, name+".pubkey"), filepath.Join(dir, name+".privkey")
+}
+
+func TestSignedShareStrictDecrypt(t *testing.T) {
+	rt := new_round_trip(t)
+	sender_pub, sender_priv := sender_keys(t, "sender")
+
+	rt.share("--sender-private-key", sender_priv)
+
+	_, err := local_backend(t, rt.destination).Head(rt.manifest_key() + manifest.SignatureSuffix)
+	assert.NoError(t, err)
+
+	rt.decrypt("--strict", "--trusted-senders", sender_pub)
+	rt.assert_decrypted()
+}
+
+func TestStrictDecryptRefusesUnsignedBatch(t *testing.T) {
+	rt := new_round_trip(t)
+	sender_pub, _ := sender_keys(t, "sender")
+
+	rt.share()
+
+	out, err := rt.try_decrypt("--strict", "--trusted-senders", sender_pub)
+	assert.Error(t, err)
+	assert.Contains(t, out, "manifest is not signed")
+
+	// without strict mode the batch is still decrypted, with a warning
+	rt.decrypt("--trusted-senders", sender_pub)
+	rt.assert_decrypted()
+}
+
+func TestStrictDecryptRefusesForgedBatch(t *testing.T) {
+	rt := new_round_trip(t)
+	sender_pub, _ := sender_keys(t, "sender")
+	_, forger_priv := sender_keys(t, "forger")
+
+	rt.share("--sender-private-key", forger_priv)
+
+	out, err := rt.try_decrypt("--strict", "--trusted-senders", sender_pub)
+	assert.Error(t, err)
+	assert.Contains(t, out, "Refusing batch")
+}
+
+// a forged manifest signature alone is not enough, every object is checked too
+func TestStrictDecryptRefusesForgedObjects(t *testing.T) {
+	rt := new_round_trip(t)
+	sender_pub, sender_priv := sender_keys(t, "sender")
+	_, forger_priv := sender_keys(t, "forger")
+
+	rt.share("--sender-private-key", forger_priv)
+
+	// re-sign just the manifest with the trusted key
+	backend := local_backend(t, rt.destination)
+	body, err := backend.Get(rt.manifest_key())
+	require.NoError(t, err)
+	data, _ := io.ReadAll(body)
+	body.Close()
+	signature, err := encrypt.SignDetached(encrypt.GetSignKey(nil, options.Options{SignKey: sender_priv}), data)
+	require.NoError(t, err)
+	require.NoError(t, backend.PutStream(rt.manifest_key()+manifest.SignatureSuffix, bytes.NewReader(signature)))
+
+	for _, streaming := range []string{"--streaming=false", "--streaming"} {
+		out, err := rt.try_decrypt("--strict", "--trusted-senders", sender_pub, streaming)
+		assert.Error(t, err)
+		assert.Contains(t, out, "signed by untrusted key")
+	}
+}
@@ -0,0 +1,125 @@
+package main_test
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	encrypt "github.com/tempuslabs/s3s2/encrypt"
+	manifest "github.com/tempuslabs/s3s2/manifest"
+	storage "github.com/tempuslabs/s3s2/storage"
+)
+
+// a stand-in for a Vault dev server with a KV v2 mount at secret/ and a transit mount at transit/.
+// Transit ciphertexts are simply the data key with a prefix.
+func fake_vault(t *testing.T, secrets map[string]map[string]string, calls map[string]int, mu *sync.Mutex) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("X-Vault-Token") != "s3s2-token" {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string][]string{"errors": {"permission denied"}})
+			return
+		}
+		var request struct{ Ciphertext string }
+		json.NewDecoder(r.Body).Decode(&request)
+
+		path := strings.TrimPrefix(r.URL.Path, "/v1/")
+		var data interface{}
+		switch {
+		case strings.HasPrefix(path, "secret/data/") && secrets[strings.TrimPrefix(path, "secret/data/")] != nil:
+			data = map[string]interface{}{"data": secrets[strings.TrimPrefix(path, "secret/data/")]}
+		case path == "transit/datakey/plaintext/s3s2":
+			calls["datakey"]++
+			key := make([]byte, 32)
+			rand.Read(key)
+			plaintext := base64.StdEncoding.EncodeToString(key)
+			data = map[string]string{"plaintext": plaintext, "ciphertext": "vault:v1:" + plaintext}
+		case path == "transit/decrypt/s3s2":
+			calls["decrypt"]++
+			data = map[string]string{"plaintext": strings.TrimPrefix(request.Ciphertext, "vault:v1:")}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string][]string{"errors": {}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "s3s2-token")
+	return server
+}
+
+// the receiver's keys are read from KV secrets instead of local files
+func TestVaultKvKeys(t *testing.T) {
+	rt := new_round_trip(t)
+	pub_key, err := os.ReadFile(rt.pub_key)
+	require.NoError(t, err)
+	priv_key, err := os.ReadFile(rt.priv_key)
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	server := fake_vault(t, map[string]map[string]string{
+		"s3s2/receiver": {"pubkey": string(pub_key), "privkey": string(priv_key)},
+		"s3s2/public":   {"key": string(pub_key)},
+	}, map[string]int{}, &mu)
+	defer server.Close()
+
+	// a secret with a single field needs no field name
+	rt.pub_key = "vault://secret/s3s2/public"
+	rt.share()
+
+	rt.priv_key = "vault://secret/s3s2/receiver#privkey"
+	rt.decrypt()
+	rt.assert_decrypted()
+
+	rt.priv_key = "vault://secret/s3s2/receiver"
+	rt.decrypted = t.TempDir()
+	out, err := rt.try_decrypt()
+	assert.Error(t, err)
+	assert.Contains(t, out, "has 2 fields")
+
+	rt.priv_key = "vault://secret/s3s2/missing#privkey"
+	out, err = rt.try_decrypt()
+	assert.Error(t, err)
+	assert.Contains(t, out, "Vault returned 404")
+}
+
+// a batch sealed with transit data keys decrypts without any GPG keys, the transit key never leaves Vault
+func TestVaultTransitShare(t *testing.T) {
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	server := fake_vault(t, nil, calls, &mu)
+	defer server.Close()
+
+	rt := new_round_trip(t)
+	rt.pub_key, rt.priv_key = "", ""
+	rt.share("--vault-transit-key", "transit/s3s2")
+
+	target := filepath.Join(t.TempDir(), "s3s2_manifest.json")
+	_, err := storage.DownloadFile(local_backend(t, rt.destination), rt.manifest_key(), target)
+	require.NoError(t, err)
+	m := manifest.ReadManifest(target)
+	assert.Equal(t, encrypt.FormatTransit, m.Format)
+	for _, f := range m.Files {
+		assert.True(t, strings.HasPrefix(f.DataKey, "vault:v1:"), f.Name)
+	}
+	assert.Equal(t, len(m.Files), calls["datakey"])
+
+	out, err := rt.try_decrypt()
+	assert.Error(t, err)
+	assert.Contains(t, out, "--vault-transit-key")
+
+	rt.decrypt("--vault-transit-key", "transit/s3s2", "--vault-addr", server.URL)
+	rt.assert_decrypted()
+	assert.Equal(t, len(m.Files), calls["decrypt"])
+}
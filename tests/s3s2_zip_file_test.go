@@ -1,7 +1,10 @@
 package main_test
 
 import (
+	"bytes"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 
 	"io"
@@ -91,3 +94,40 @@ func TestUnZipFileCreation(t * testing.T) {
 
     os.RemoveAll("s3s2_test_zip_file_creation")
 }
+
+// Test that a zip can be extracted front to back without random access
+func TestUnZipStream(t * testing.T) {
+    assert := assert.New(t)
+    dir := t.TempDir()
+
+    input_file_path := filepath.Join(dir, "image_001.pdf")
+    writeToFile(input_file_path, strings.Repeat("This is test data", 1000))
+
+    var zipped bytes.Buffer
+    assert.NoError(zip.ZipStream(input_file_path, "image_001.pdf", &zipped))
+
+    assert.NoError(zip.UnZipStream(&zipped, "extracted.pdf", dir))
+
+    expected, _ := os.ReadFile(input_file_path)
+    actual, _ := os.ReadFile(filepath.Join(dir, "extracted.pdf"))
+    assert.Equal(expected, actual)
+}
+
+// Test that corrupted zip contents are detected while streaming
+func TestUnZipStreamChecksumMismatch(t * testing.T) {
+    assert := assert.New(t)
+    dir := t.TempDir()
+
+    input_file_path := filepath.Join(dir, "image_001.pdf")
+    writeToFile(input_file_path, "This is test data")
+
+    var zipped bytes.Buffer
+    assert.NoError(zip.ZipStream(input_file_path, "image_001.pdf", &zipped))
+
+    // flip a bit of the crc in the data descriptor that trails the entry
+    data := zipped.Bytes()
+    descriptor := bytes.Index(data, []byte{0x50, 0x4b, 0x07, 0x08})
+    data[descriptor+4] ^= 0xff
+
+    assert.ErrorContains(zip.UnZipStream(bytes.NewReader(data), "extracted.pdf", dir), "checksum mismatch")
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
 	"strings"
@@ -112,9 +113,20 @@ func Include(vs []string, t string) bool {
     return index(vs, t) >= 0
 }
 
+// Splits a comma-separated argument into its trimmed, non-empty items
+func SplitList(s string) []string {
+    var items []string
+    for _, item := range strings.Split(s, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            items = append(items, item)
+        }
+    }
+    return items
+}
+
 // Influence creation of the retry logic used by any aws-config-using tools
 func getRetryer() retryer.CustomRetryer {
-    retryer := retryer.CustomRetryer{client.DefaultRetryer{NumMaxRetries:10}}
+    retryer := retryer.CustomRetryer{DefaultRetryer: client.DefaultRetryer{NumMaxRetries:10}}
     return retryer
 }
 
@@ -127,31 +139,63 @@ func getAwsConfig(opts options.Options) aws.Config {
     return conf
 }
 
+// S3 specific config - lets us target S3-compatible object stores such as MinIO or Ceph RGW
+// kept off the session so other services like SSM keep using their AWS endpoints
+func GetS3Config(opts options.Options) *aws.Config {
+    conf := aws.NewConfig()
+    if opts.EndpointUrl != "" {
+        conf = conf.WithEndpoint(opts.EndpointUrl)
+    }
+    if opts.PathStyle {
+        conf = conf.WithS3ForcePathStyle(true)
+    }
+    return conf
+}
+
+// KMS specific config - lets envelope encryption reach KMS through a VPC endpoint
+func GetKmsConfig(opts options.Options) *aws.Config {
+    conf := aws.NewConfig()
+    if opts.KmsEndpointUrl != "" {
+        conf = conf.WithEndpoint(opts.KmsEndpointUrl)
+    }
+    return conf
+}
+
+// Session options shared by every way of establishing an AWS session
+func getSessionOptions(opts options.Options) session.Options {
+    sess_opts := session.Options{
+        Config: getAwsConfig(opts),
+    }
+
+    // on-prem object stores are frequently signed by a private CA
+    if opts.CaBundle != "" {
+        bundle, err := os.ReadFile(opts.CaBundle)
+        PanicIfError("Unable to read CA bundle - ", err)
+        sess_opts.CustomCABundle = bytes.NewReader(bundle)
+    }
+    return sess_opts
+}
+
 // Easily add new command line arguments to influence the creation of AWS sessions
 func GetAwsSession(opts options.Options) *session.Session {
     var sess *session.Session
+    sess_opts := getSessionOptions(opts)
 
     // intended on share when ran on partner server using credential files
     if opts.AwsProfile != "" {
         log.Debugf("Using AWS Profile '%s'", opts.AwsProfile)
-        sess = session.Must(session.NewSessionWithOptions(session.Options{
-        Profile: opts.AwsProfile,
-        Config: getAwsConfig(opts),
-        SharedConfigState: session.SharedConfigEnable,
-        }))
+        sess_opts.Profile = opts.AwsProfile
+        sess_opts.SharedConfigState = session.SharedConfigEnable
+        sess = session.Must(session.NewSessionWithOptions(sess_opts))
     // intended on decrypt when ran on ec2 instance using sts
     } else if opts.AwsRoleArn != "" {
         log.Debugf("Using AWS Role ARN '%s'", opts.AwsRoleArn)
-        sess = session.Must(session.NewSessionWithOptions(session.Options{
-        Config: getAwsConfig(opts),
-        }))
+        sess = session.Must(session.NewSessionWithOptions(sess_opts))
         tokenRetriever := federated_identity.FederatedIdentityTokenRetriever{}
         federated_identity.FederatedIdentityConfig(sess, &opts.AwsRoleArn, &tokenRetriever)
     } else {
-        sess = session.Must(session.NewSessionWithOptions(session.Options{
-        Config: getAwsConfig(opts),
-        AssumeRoleDuration: 12 * time.Hour,
-        }))
+        sess_opts.AssumeRoleDuration = 12 * time.Hour
+        sess = session.Must(session.NewSessionWithOptions(sess_opts))
     }
     return sess
 }
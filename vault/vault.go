@@ -0,0 +1,191 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+	log "github.com/sirupsen/logrus"
+
+	options "github.com/tempuslabs/s3s2/options"
+)
+
+// Key options given as vault://<mount>/<path>#<field> are read from a KV v2 secret instead of a local file
+const scheme = "vault://"
+
+// IsRef reports whether a key option refers to a Vault secret
+func IsRef(s string) bool {
+	return strings.HasPrefix(s, scheme)
+}
+
+// Client talks to the Vault HTTP API, configured the same way as the vault CLI
+type Client struct {
+	addr      string
+	token     string
+	namespace string
+	http      *http.Client
+}
+
+// NewClient connects to opts.VaultAddr, or $VAULT_ADDR when it is not set.
+// The token is read from $VAULT_TOKEN or the ~/.vault-token file the vault CLI writes on login.
+func NewClient(opts options.Options) (*Client, error) {
+	addr := opts.VaultAddr
+	if addr == "" {
+		addr = os.Getenv("VAULT_ADDR")
+	}
+	if addr == "" {
+		return nil, errors.New("no Vault address, set --vault-addr or VAULT_ADDR")
+	}
+
+	token := os.Getenv("VAULT_TOKEN")
+	if token == "" {
+		if home, err := homedir.Dir(); err == nil {
+			data, _ := os.ReadFile(filepath.Join(home, ".vault-token"))
+			token = strings.TrimSpace(string(data))
+		}
+	}
+	if token == "" {
+		return nil, errors.New("no Vault token, set VAULT_TOKEN or log in with the vault CLI")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if ca_cert := os.Getenv("VAULT_CACERT"); ca_cert != "" {
+		pem, err := os.ReadFile(ca_cert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in '%s'", ca_cert)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	return &Client{
+		addr:      strings.TrimRight(addr, "/"),
+		token:     token,
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+		http:      &http.Client{Transport: transport, Timeout: 30 * time.Second},
+	}, nil
+}
+
+// request sends body to the API path and decodes the data of the response into out
+func (c *Client) request(method string, path string, body interface{}, out interface{}) error {
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method, c.addr+"/v1/"+strings.TrimLeft(path, "/"), &payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", c.token)
+	req.Header.Set("X-Vault-Request", "true")
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var response struct {
+		Data   json.RawMessage
+		Errors []string
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil && resp.StatusCode < 300 {
+		return fmt.Errorf("invalid response from Vault for '%s' - %s", path, err)
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("Vault returned %d for '%s' - %s", resp.StatusCode, path, strings.Join(response.Errors, ", "))
+	}
+	return json.Unmarshal(response.Data, out)
+}
+
+// splitPath separates the mount from the rest of a path, KV and transit mounts are the first segment
+func splitPath(path string) (string, string, error) {
+	mount, rest, ok := strings.Cut(strings.Trim(path, "/"), "/")
+	if !ok || mount == "" || rest == "" {
+		return "", "", fmt.Errorf("'%s' must be <mount>/<path>", path)
+	}
+	return mount, rest, nil
+}
+
+// ReadKV reads a field of a KV v2 secret referenced as vault://<mount>/<path>#<field>.
+// The field may be left out when the secret only has one.
+func (c *Client) ReadKV(ref string) (string, error) {
+	path, field, _ := strings.Cut(strings.TrimPrefix(ref, scheme), "#")
+	mount, secret, err := splitPath(path)
+	if err != nil {
+		return "", err
+	}
+
+	log.Debugf("Reading Vault secret '%s'", path)
+	var data struct {
+		Data map[string]interface{}
+	}
+	if err := c.request(http.MethodGet, mount+"/data/"+secret, nil, &data); err != nil {
+		return "", err
+	}
+
+	if field == "" {
+		if len(data.Data) != 1 {
+			return "", fmt.Errorf("Vault secret '%s' has %d fields, name one as %s%s#<field>", path, len(data.Data), scheme, path)
+		}
+		for name := range data.Data {
+			field = name
+		}
+	}
+	value, ok := data.Data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("Vault secret '%s' has no field '%s'", path, field)
+	}
+	return value, nil
+}
+
+// GenerateDataKey asks the transit key, given as <mount>/<name>, for a new 256 bit data key.
+// Returns the key along with its copy wrapped by the transit key.
+func (c *Client) GenerateDataKey(key string) ([]byte, string, error) {
+	mount, name, err := splitPath(key)
+	if err != nil {
+		return nil, "", err
+	}
+	var data struct {
+		Plaintext  string
+		Ciphertext string
+	}
+	if err := c.request(http.MethodPost, mount+"/datakey/plaintext/"+name, map[string]int{"bits": 256}, &data); err != nil {
+		return nil, "", err
+	}
+	plaintext, err := base64.StdEncoding.DecodeString(data.Plaintext)
+	return plaintext, data.Ciphertext, err
+}
+
+// DecryptDataKey unwraps a data key with the transit key it was generated under, the key itself never leaves Vault
+func (c *Client) DecryptDataKey(key string, ciphertext string) ([]byte, error) {
+	mount, name, err := splitPath(key)
+	if err != nil {
+		return nil, err
+	}
+	var data struct {
+		Plaintext string
+	}
+	if err := c.request(http.MethodPost, mount+"/decrypt/"+name, map[string]string{"ciphertext": ciphertext}, &data); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(data.Plaintext)
+}
@@ -2,7 +2,13 @@ package zip
 
 import (
 	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"os"
@@ -13,6 +19,16 @@ import (
 	utils "github.com/tempuslabs/s3s2/utils"
 )
 
+// Files are compressed by the codec share encrypts them with, so the zip is only a container.
+// Entries are still deflated, at no compression, because stored entries can't be streamed by UnZipStream.
+func newZipWriter(out io.Writer) *zip.Writer {
+	zipWriter := zip.NewWriter(out)
+	zipWriter.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, flate.NoCompression)
+	})
+	return zipWriter
+}
+
 // ZipFile zips the provided file.
 func ZipFile(InputFn string, OutputFn string, directory string) string {
 
@@ -26,7 +42,7 @@ func ZipFile(InputFn string, OutputFn string, directory string) string {
 	utils.PanicIfError("Unable to create zip file - ", err)
 	defer newZipFile.Close()
 
-	zipWriter := zip.NewWriter(newZipFile)
+	zipWriter := newZipWriter(newZipFile)
 	defer zipWriter.Close()
 
 	zipfile, err := os.Open(InputFn)
@@ -44,7 +60,7 @@ func ZipFile(InputFn string, OutputFn string, directory string) string {
 	// to preserve the folder structure we can overwrite this with the full path.
 	header.Name = strings.Replace(InputFn, directory, "", -1)
 
-	// Change to deflate to gain better compression
+	// see newZipWriter, the entry is deflated without compressing it
 	// see http://golang.org/pkg/archive/zip/#pkg-constants
 	header.Method = zip.Deflate
 
@@ -58,6 +74,50 @@ func ZipFile(InputFn string, OutputFn string, directory string) string {
 	return OutputFn
 }
 
+// ZipStream zips the provided file straight into out under the given name, nothing is written to disk.
+// The zip is finalized but out is left open.
+func ZipStream(InputFn string, name string, out io.Writer) error {
+
+	log.Debugf("Streaming zip of file '%s'", InputFn)
+
+	zipfile, err := os.Open(InputFn)
+	if err != nil {
+		return err
+	}
+	defer zipfile.Close()
+
+	info, err := zipfile.Stat()
+	if err != nil {
+		return err
+	}
+
+	return ZipReader(zipfile, info, name, out)
+}
+
+// ZipReader zips everything read from in into out under the given name, with the size, mode and time of info.
+// The zip is finalized but out is left open.
+func ZipReader(in io.Reader, info os.FileInfo, name string, out io.Writer) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(name)
+	header.Method = zip.Deflate
+
+	zipWriter := newZipWriter(out)
+
+	writer, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(writer, in); err != nil {
+		return err
+	}
+
+	return zipWriter.Close()
+}
+
 // ZipFile zips the provided file.
 func ZipFileInMemory(InputFn string, date_folder string) *bytes.Buffer {
 
@@ -131,3 +191,126 @@ func UnZipFile(InputFn string, OutputFn string, directory string) string {
 	}
 	return OutputFn
 }
+
+const (
+	localFileHeaderSignature = 0x04034b50
+	dataDescriptorSignature  = 0x08074b50
+	uint32max                = (1 << 32) - 1
+)
+
+// countingReader tracks how many bytes have been pulled from the underlying stream
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// UnZipStream extracts a zip read front to back from a stream, archive/zip needs random access to the
+// central directory at the end of the file which a download or decryption stream can't offer.
+// Like UnZipFile every entry is extracted to OutputFn within directory.
+func UnZipStream(in io.Reader, OutputFn string, directory string) error {
+	counter := &countingReader{r: in}
+	reader := bufio.NewReader(counter)
+	consumed := func() int64 { return counter.n - int64(reader.Buffered()) }
+
+	extractedFilePath := filepath.Join(directory, OutputFn)
+
+	for {
+		var signature uint32
+		if err := binary.Read(reader, binary.LittleEndian, &signature); err != nil {
+			return err
+		}
+		// the central directory follows the last entry, it only repeats what we have already read
+		if signature != localFileHeaderSignature {
+			return nil
+		}
+
+		var header struct {
+			Version, Flags, Method, ModTime, ModDate uint16
+			CRC32, CompressedSize, UncompressedSize  uint32
+			NameLength, ExtraLength                  uint16
+		}
+		if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+			return err
+		}
+		name := make([]byte, header.NameLength)
+		if _, err := io.ReadFull(reader, name); err != nil {
+			return err
+		}
+		if _, err := reader.Discard(int(header.ExtraLength)); err != nil {
+			return err
+		}
+
+		has_data_descriptor := header.Flags&0x8 != 0
+		is_dir := strings.HasSuffix(string(name), "/")
+
+		var body io.Reader
+		switch header.Method {
+		case zip.Deflate:
+			body = flate.NewReader(reader)
+		case zip.Store:
+			if has_data_descriptor {
+				return fmt.Errorf("unable to stream stored zip entry '%s' without a known size", name)
+			}
+			body = io.LimitReader(reader, int64(header.CompressedSize))
+		default:
+			return fmt.Errorf("unsupported compression method %d for zip entry '%s'", header.Method, name)
+		}
+
+		start := consumed()
+		checksum := crc32.NewIEEE()
+		var written int64
+
+		if is_dir {
+			os.MkdirAll(extractedFilePath, os.ModePerm)
+			log.Debugf("Directory Created: '%s'", extractedFilePath)
+			if _, err := io.Copy(checksum, body); err != nil {
+				return err
+			}
+		} else {
+			os.MkdirAll(filepath.Dir(extractedFilePath), os.ModePerm)
+
+			outputFile, err := os.OpenFile(extractedFilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			written, err = io.Copy(io.MultiWriter(outputFile, checksum), body)
+			outputFile.Close()
+			if err != nil {
+				return err
+			}
+			log.Debugf("\tFile extracted to: '%s'", extractedFilePath)
+		}
+		compressed_size := consumed() - start
+
+		expected_crc := header.CRC32
+		if has_data_descriptor {
+			var descriptor struct{ Signature, CRC32 uint32 }
+			if err := binary.Read(reader, binary.LittleEndian, &descriptor); err != nil {
+				return err
+			}
+			if descriptor.Signature != dataDescriptorSignature {
+				return errors.New("zip data descriptor is missing its signature")
+			}
+			expected_crc = descriptor.CRC32
+
+			// sizes are only widened to 64 bits when they don't fit in 32
+			size_length := 8
+			if compressed_size > uint32max || written > uint32max {
+				size_length = 16
+			}
+			if _, err := reader.Discard(size_length); err != nil {
+				return err
+			}
+		}
+
+		if checksum.Sum32() != expected_crc {
+			return fmt.Errorf("checksum mismatch extracting zip entry '%s'", name)
+		}
+	}
+}
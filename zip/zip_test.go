@@ -0,0 +1,54 @@
+package zip
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// a streamed zip is a regular archive that can also be extracted front to back
+func TestZipStreamRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "b.txt")
+	content := bytes.Repeat([]byte("nested file "), 10000)
+	require.NoError(t, os.WriteFile(input, content, 0600))
+
+	var archive bytes.Buffer
+	require.NoError(t, ZipStream(input, filepath.Join("nested", "b.txt"), &archive))
+
+	reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	require.NoError(t, err)
+	require.Len(t, reader.File, 1)
+	assert.Equal(t, "nested/b.txt", reader.File[0].Name)
+	entry, err := reader.File[0].Open()
+	require.NoError(t, err)
+	unzipped, err := io.ReadAll(entry)
+	require.NoError(t, err)
+	assert.Equal(t, content, unzipped)
+
+	out := t.TempDir()
+	require.NoError(t, UnZipStream(&archive, filepath.Join("nested", "b.txt"), out))
+	extracted, err := os.ReadFile(filepath.Join(out, "nested", "b.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, content, extracted)
+}
+
+// a damaged entry is refused rather than extracted
+func TestUnZipStreamChecksEntries(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "a.txt")
+	require.NoError(t, os.WriteFile(input, []byte("top level file"), 0600))
+
+	var archive bytes.Buffer
+	require.NoError(t, ZipStream(input, "a.txt", &archive))
+	damaged := archive.Bytes()
+	damaged[bytes.Index(damaged, []byte("top level file"))] ^= 0xff
+
+	assert.Error(t, UnZipStream(bytes.NewReader(damaged), "a.txt", t.TempDir()))
+}